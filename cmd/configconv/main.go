@@ -0,0 +1,73 @@
+// Command configconv converts gateway configuration files between YAML, JSON
+// and TOML.
+//
+// Usage:
+//
+//	configconv -in config.yaml -out config.json
+//	cat config.toml | configconv -from toml -to yaml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/leo-andrei/api-gateway/config"
+)
+
+func main() {
+	in := flag.String("in", "", "input file (defaults to stdin)")
+	out := flag.String("out", "", "output file (defaults to stdout)")
+	from := flag.String("from", "", "input format: yaml, json or toml (defaults to the input file extension)")
+	to := flag.String("to", "", "output format: yaml, json or toml (defaults to the output file extension)")
+	flag.Parse()
+
+	if err := run(*in, *out, *from, *to); err != nil {
+		fmt.Fprintf(os.Stderr, "configconv: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(in, out, from, to string) error {
+	fromFormat, err := resolveFormat(from, in)
+	if err != nil {
+		return err
+	}
+	toFormat, err := resolveFormat(to, out)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if in != "" {
+		inFile, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer inFile.Close()
+		r = inFile
+	}
+
+	cfg, err := config.Decode(r, fromFormat)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		return config.Encode(os.Stdout, cfg, toFormat)
+	}
+	return config.SaveConfig(cfg, out, toFormat)
+}
+
+// resolveFormat prefers an explicit format name, then the file extension,
+// then YAML
+func resolveFormat(name, filename string) (config.Format, error) {
+	if name != "" {
+		return config.ParseFormat(name)
+	}
+	if filename != "" {
+		return config.FormatFromPath(filename), nil
+	}
+	return config.FormatYAML, nil
+}
@@ -1,45 +1,376 @@
 package config
 
 import (
+	"crypto/tls"
+	"fmt"
+	"maps"
 	"os"
+	"strings"
 
-	"gopkg.in/yaml.v2"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+	"github.com/leo-andrei/api-gateway/internal/metrics"
+	"github.com/leo-andrei/api-gateway/internal/requestid"
+	"github.com/leo-andrei/api-gateway/internal/tracing"
 )
 
 // Config holds the application configuration
 type Config struct {
-	Server struct {
-		Port int `yaml:"port"`
-	} `yaml:"server"`
-	Logging struct {
-		Level  string `yaml:"level"`
-		Format string `yaml:"format"`
-	} `yaml:"logging"`
-	Routes []Route `yaml:"routes"`
+	Server    ServerConfig              `yaml:"server" json:"server" toml:"server"`
+	Admin     AdminConfig               `yaml:"admin,omitempty" json:"admin,omitempty" toml:"admin,omitempty"`
+	Health    HealthConfig              `yaml:"health,omitempty" json:"health,omitempty" toml:"health,omitempty"`
+	Logging   logging.LoggingConfig     `yaml:"logging" json:"logging" toml:"logging"`
+	Metrics   metrics.MetricsConfig     `yaml:"metrics,omitempty" json:"metrics,omitempty" toml:"metrics,omitempty"`
+	Tracing   tracing.TracingConfig     `yaml:"tracing,omitempty" json:"tracing,omitempty" toml:"tracing,omitempty"`
+	RequestID requestid.RequestIDConfig `yaml:"requestId,omitempty" json:"requestId,omitempty" toml:"requestId,omitempty"`
+	Discovery DiscoveryConfig           `yaml:"discovery,omitempty" json:"discovery,omitempty" toml:"discovery,omitempty"`
+	Upstreams []Upstream                `yaml:"upstreams,omitempty" json:"upstreams,omitempty" toml:"upstreams,omitempty"`
+	Services  []Service                 `yaml:"services,omitempty" json:"services,omitempty" toml:"services,omitempty"`
+	Routes    []Route                   `yaml:"routes" json:"routes" toml:"routes"`
+}
+
+// ServerConfig holds the HTTP server configuration
+type ServerConfig struct {
+	Port              int                 `yaml:"port" json:"port" toml:"port"`
+	Address           string              `yaml:"address,omitempty" json:"address,omitempty" toml:"address,omitempty"`                              // Bind address of every port, all interfaces when empty
+	Listeners         []Listener          `yaml:"listeners,omitempty" json:"listeners,omitempty" toml:"listeners,omitempty"`                        // Additional plain listeners
+	H2C               bool                `yaml:"h2c,omitempty" json:"h2c,omitempty" toml:"h2c,omitempty"`                                          // Accept cleartext HTTP/2 on the plain listeners
+	ReadTimeout       Duration            `yaml:"readTimeout,omitempty" json:"readTimeout,omitempty" toml:"readTimeout,omitzero"`                   // Time to read a whole request, body included
+	ReadHeaderTimeout Duration            `yaml:"readHeaderTimeout,omitempty" json:"readHeaderTimeout,omitempty" toml:"readHeaderTimeout,omitzero"` // Time to read the request headers
+	WriteTimeout      Duration            `yaml:"writeTimeout,omitempty" json:"writeTimeout,omitempty" toml:"writeTimeout,omitzero"`                // Time from the end of the headers to the end of the response
+	IdleTimeout       Duration            `yaml:"idleTimeout,omitempty" json:"idleTimeout,omitempty" toml:"idleTimeout,omitzero"`                   // Time a keep-alive connection may wait for the next request
+	MaxHeaderBytes    int                 `yaml:"maxHeaderBytes,omitempty" json:"maxHeaderBytes,omitempty" toml:"maxHeaderBytes,omitzero"`
+	MaxConnections    int                 `yaml:"maxConnections,omitempty" json:"maxConnections,omitempty" toml:"maxConnections,omitzero"` // Concurrent connections per listener
+	ProxyProtocol     ProxyProtocolConfig `yaml:"proxyProtocol,omitempty" json:"proxyProtocol,omitempty" toml:"proxyProtocol,omitempty"`
+	TrustedProxies    []string            `yaml:"trustedProxies,omitempty" json:"trustedProxies,omitempty" toml:"trustedProxies,omitempty"` // CIDRs of proxies whose X-Forwarded-For and Forwarded headers are kept
+	Shutdown          ShutdownConfig      `yaml:"shutdown,omitempty" json:"shutdown,omitempty" toml:"shutdown,omitempty"`
+	TLS               TLSConfig           `yaml:"tls,omitempty" json:"tls,omitempty" toml:"tls,omitempty"`
+}
+
+// ShutdownConfig controls how the gateway stops. On SIGTERM /ready fails
+// first, then new requests are still served for DrainDelay so load balancers
+// notice, then in-flight requests get Timeout to finish before they are cut
+// off.
+type ShutdownConfig struct {
+	DrainDelay Duration `yaml:"drainDelay,omitempty" json:"drainDelay,omitempty" toml:"drainDelay,omitzero"`
+	Timeout    Duration `yaml:"timeout,omitempty" json:"timeout,omitempty" toml:"timeout,omitzero"`
+}
+
+// Listener networks
+const (
+	NetworkTCP  = "tcp"
+	NetworkUnix = "unix"
+)
+
+// Listener is an additional plain listener, on a TCP address such as
+// "127.0.0.1:9090" or a Unix domain socket path
+type Listener struct {
+	Network       string `yaml:"network,omitempty" json:"network,omitempty" toml:"network,omitempty"` // tcp (default) or unix
+	Address       string `yaml:"address" json:"address" toml:"address"`
+	ProxyProtocol bool   `yaml:"proxyProtocol,omitempty" json:"proxyProtocol,omitempty" toml:"proxyProtocol,omitempty"` // Expect PROXY protocol headers
+}
+
+// ProxyProtocolConfig configures PROXY protocol v1 and v2 headers, which TCP
+// load balancers send to pass on the client address. Headers are only
+// accepted from TrustedCIDRs; connections from other sources that send one
+// are rejected.
+type ProxyProtocolConfig struct {
+	Enabled      bool     `yaml:"enabled" json:"enabled" toml:"enabled"` // Expect headers on the main and TLS ports
+	TrustedCIDRs []string `yaml:"trustedCidrs,omitempty" json:"trustedCidrs,omitempty" toml:"trustedCidrs,omitempty"`
+}
+
+// TLSConfig configures the TLS listener. TLS is disabled when Port is zero.
+type TLSConfig struct {
+	Port           int           `yaml:"port,omitempty" json:"port,omitempty" toml:"port,omitzero"`
+	Certificates   []Certificate `yaml:"certificates,omitempty" json:"certificates,omitempty" toml:"certificates,omitempty"`      // Selected by SNI, the first one is the default
+	MinVersion     string        `yaml:"minVersion,omitempty" json:"minVersion,omitempty" toml:"minVersion,omitempty"`            // "1.2" or "1.3", defaults to 1.2
+	CipherSuites   []string      `yaml:"cipherSuites,omitempty" json:"cipherSuites,omitempty" toml:"cipherSuites,omitempty"`      // TLS 1.2 cipher suite names, defaults to Go's secure suites
+	ReloadInterval Duration      `yaml:"reloadInterval,omitempty" json:"reloadInterval,omitempty" toml:"reloadInterval,omitzero"` // How often certificate files are checked for changes
+	ACME           ACMEConfig    `yaml:"acme,omitempty" json:"acme,omitempty" toml:"acme,omitempty"`
+	HTTP3          HTTP3Config   `yaml:"http3,omitempty" json:"http3,omitempty" toml:"http3,omitempty"`
+}
+
+// HTTP3Config configures the QUIC listener serving HTTP/3 with the TLS
+// listener's certificates. HTTP/3 is disabled when Port is zero.
+type HTTP3Config struct {
+	Port   int      `yaml:"port" json:"port" toml:"port"`                                    // UDP port, usually the same number as the TLS port
+	MaxAge Duration `yaml:"maxAge,omitempty" json:"maxAge,omitempty" toml:"maxAge,omitzero"` // How long clients may remember the Alt-Svc advertisement
+}
+
+// Enabled reports whether the HTTP/3 listener is configured
+func (h HTTP3Config) Enabled() bool {
+	return h.Port != 0
+}
+
+// ACMEConfig configures automatic certificates for the listed hosts. Hosts
+// not listed here are served from the static certificates.
+type ACMEConfig struct {
+	Hosts        []string `yaml:"hosts,omitempty" json:"hosts,omitempty" toml:"hosts,omitempty"`
+	Email        string   `yaml:"email,omitempty" json:"email,omitempty" toml:"email,omitempty"`                      // Contact address for the ACME account
+	CacheDir     string   `yaml:"cacheDir,omitempty" json:"cacheDir,omitempty" toml:"cacheDir,omitempty"`             // Where account keys and certificates are stored
+	DirectoryURL string   `yaml:"directoryUrl,omitempty" json:"directoryUrl,omitempty" toml:"directoryUrl,omitempty"` // ACME directory, defaults to Let's Encrypt
+	CAFile       string   `yaml:"caFile,omitempty" json:"caFile,omitempty" toml:"caFile,omitempty"`                   // PEM roots trusted for the ACME directory, e.g. Pebble's
+	RenewBefore  Duration `yaml:"renewBefore,omitempty" json:"renewBefore,omitempty" toml:"renewBefore,omitzero"`     // How long before expiry certificates are renewed
+}
+
+// Enabled reports whether ACME certificates are configured
+func (a ACMEConfig) Enabled() bool {
+	return len(a.Hosts) > 0
+}
+
+// Certificate is a PEM encoded certificate chain and private key on disk
+type Certificate struct {
+	CertFile string `yaml:"certFile" json:"certFile" toml:"certFile"`
+	KeyFile  string `yaml:"keyFile" json:"keyFile" toml:"keyFile"`
+}
+
+// Enabled reports whether the TLS listener is configured
+func (t TLSConfig) Enabled() bool {
+	return t.Port != 0
+}
+
+// TLSVersion returns the configured minimum TLS version
+func (t TLSConfig) TLSVersion() (uint16, error) {
+	switch t.MinVersion {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported minVersion %q", t.MinVersion)
+	}
+}
+
+// CipherSuiteIDs returns the IDs of the configured cipher suites. Only suites
+// Go considers secure are accepted.
+func (t TLSConfig) CipherSuiteIDs() ([]uint16, error) {
+	if len(t.CipherSuites) == 0 {
+		return nil, nil
+	}
+
+	available := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(t.CipherSuites))
+	for _, name := range t.CipherSuites {
+		id, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// AdminConfig holds the configuration of the admin API listener. The admin
+// API is disabled when Port is zero.
+type AdminConfig struct {
+	Address     string       `yaml:"address,omitempty" json:"address,omitempty" toml:"address,omitempty"` // Bind address, 127.0.0.1 when empty
+	Port        int          `yaml:"port,omitempty" json:"port,omitempty" toml:"port,omitzero"`
+	Tokens      []AdminToken `yaml:"tokens,omitempty" json:"tokens,omitempty" toml:"tokens,omitempty"`
+	HistorySize int          `yaml:"historySize,omitempty" json:"historySize,omitempty" toml:"historySize,omitzero"` // Applied configurations kept for rollback
+}
+
+// HealthConfig configures the liveness, readiness and health endpoints
+type HealthConfig struct {
+	Details   bool     `yaml:"details,omitempty" json:"details,omitempty" toml:"details,omitempty"`       // Serve /health/details with the checks of every subsystem
+	AdminPort bool     `yaml:"adminPort,omitempty" json:"adminPort,omitempty" toml:"adminPort,omitempty"` // Serve the endpoints on the admin port instead of the server ports
+	Timeout   Duration `yaml:"timeout,omitempty" json:"timeout,omitempty" toml:"timeout,omitzero"`        // Limit for the checks of /health/details
+}
+
+// AdminToken is a named bearer token accepted by the admin API
+type AdminToken struct {
+	Name  string `yaml:"name" json:"name" toml:"name"`
+	Token string `yaml:"token" json:"token" toml:"token"`
+}
+
+// Upstream is a named backend that routes can reference instead of a full
+// target URL
+type Upstream struct {
+	Name string `yaml:"name" json:"name" toml:"name"`
+	URL  string `yaml:"url" json:"url" toml:"url"`
+}
+
+// DiscoveryConfig configures the service discovery providers
+type DiscoveryConfig struct {
+	File   FileDiscoveryConfig   `yaml:"file,omitempty" json:"file,omitempty" toml:"file,omitempty"`
+	DNS    DNSDiscoveryConfig    `yaml:"dns,omitempty" json:"dns,omitempty" toml:"dns,omitempty"`
+	Consul ConsulDiscoveryConfig `yaml:"consul,omitempty" json:"consul,omitempty" toml:"consul,omitempty"`
+}
+
+// FileDiscoveryConfig configures the file provider, which reads a JSON or YAML
+// map of service names to "host:port" endpoints
+type FileDiscoveryConfig struct {
+	Path     string   `yaml:"path,omitempty" json:"path,omitempty" toml:"path,omitempty"`
+	Interval Duration `yaml:"interval,omitempty" json:"interval,omitempty" toml:"interval,omitzero"` // How often the file is checked for changes
+}
+
+// DNSDiscoveryConfig configures the DNS provider
+type DNSDiscoveryConfig struct {
+	TTL      Duration `yaml:"ttl,omitempty" json:"ttl,omitempty" toml:"ttl,omitzero"`                 // How often records are re-resolved
+	Resolver string   `yaml:"resolver,omitempty" json:"resolver,omitempty" toml:"resolver,omitempty"` // host:port of the DNS server, defaults to the system resolver
+}
+
+// ConsulDiscoveryConfig configures the Consul provider, which watches the
+// healthy instances of a service with blocking queries
+type ConsulDiscoveryConfig struct {
+	Address    string   `yaml:"address,omitempty" json:"address,omitempty" toml:"address,omitempty"`          // Consul HTTP API address, defaults to http://127.0.0.1:8500
+	Token      string   `yaml:"token,omitempty" json:"token,omitempty" toml:"token,omitempty"`                // ACL token
+	Datacenter string   `yaml:"datacenter,omitempty" json:"datacenter,omitempty" toml:"datacenter,omitempty"` // Default datacenter, defaults to the agent's
+	WaitTime   Duration `yaml:"waitTime,omitempty" json:"waitTime,omitempty" toml:"waitTime,omitzero"`        // Maximum duration of a blocking query
+}
+
+// Discovery providers
+const (
+	ProviderFile   = "file"
+	ProviderDNS    = "dns"
+	ProviderConsul = "consul"
+)
+
+// Service is a named backend whose endpoints are found through service
+// discovery
+type Service struct {
+	Name     string `yaml:"name" json:"name" toml:"name"`
+	Provider string `yaml:"provider" json:"provider" toml:"provider"`
+	Scheme   string `yaml:"scheme,omitempty" json:"scheme,omitempty" toml:"scheme,omitempty"`
+	Host     string `yaml:"host,omitempty" json:"host,omitempty" toml:"host,omitempty"` // DNS name resolved to A/AAAA records
+	Port     int    `yaml:"port,omitempty" json:"port,omitempty" toml:"port,omitzero"`  // Port used with A/AAAA records
+	SRV      string `yaml:"srv,omitempty" json:"srv,omitempty" toml:"srv,omitempty"`    // DNS name resolved to SRV records
+
+	Tags       []string `yaml:"tags,omitempty" json:"tags,omitempty" toml:"tags,omitempty"`                   // Consul tags every instance must have
+	Datacenter string   `yaml:"datacenter,omitempty" json:"datacenter,omitempty" toml:"datacenter,omitempty"` // Consul datacenter, overrides discovery.consul.datacenter
 }
 
 // Route represents a route configuration
 type Route struct {
-	Path        string `yaml:"path"`
-	TargetURL   string `yaml:"targetUrl"`
-	Method      string `yaml:"method"`
-	RequireAuth bool   `yaml:"requireAuth"`
+	Name        string `yaml:"name,omitempty" json:"name,omitempty" toml:"name,omitempty"`
+	Path        string `yaml:"path" json:"path" toml:"path"`
+	Upstream    string `yaml:"upstream,omitempty" json:"upstream,omitempty" toml:"upstream,omitempty"`
+	Service     string `yaml:"service,omitempty" json:"service,omitempty" toml:"service,omitempty"`
+	TargetURL   string `yaml:"targetUrl" json:"targetUrl" toml:"targetUrl"`
+	Method      string `yaml:"method" json:"method" toml:"method"`
+	RequireAuth bool   `yaml:"requireAuth" json:"requireAuth" toml:"requireAuth"`
+	Protocol    string `yaml:"protocol,omitempty" json:"protocol,omitempty" toml:"protocol,omitempty"` // Protocol spoken to the upstream, negotiated when empty
+}
+
+// Upstream protocols
+const (
+	ProtocolHTTP1 = "http1"
+	ProtocolH2    = "h2"
+	ProtocolH2C   = "h2c"
+)
+
+// FindRoute returns the index of the route with the given name, or -1
+func (c *Config) FindRoute(name string) int {
+	for i, route := range c.Routes {
+		if route.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// FindUpstream returns the index of the upstream with the given name, or -1
+func (c *Config) FindUpstream(name string) int {
+	for i, upstream := range c.Upstreams {
+		if upstream.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// FindService returns the index of the service with the given name, or -1
+func (c *Config) FindService(name string) int {
+	for i, service := range c.Services {
+		if service.Name == name {
+			return i
+		}
+	}
+	return -1
 }
 
-// LoadConfig loads the configuration from a file
+// TargetURL resolves the URL a route forwards to. Routes that reference an
+// upstream treat their own targetUrl as a path relative to the upstream URL.
+func (c *Config) TargetURL(route Route) string {
+	if route.Upstream == "" {
+		return route.TargetURL
+	}
+	i := c.FindUpstream(route.Upstream)
+	if i < 0 {
+		return route.TargetURL
+	}
+	if route.TargetURL == "" {
+		return c.Upstreams[i].URL
+	}
+	return strings.TrimSuffix(c.Upstreams[i].URL, "/") + "/" + strings.TrimPrefix(route.TargetURL, "/")
+}
+
+// Clone returns a deep copy of the configuration
+func (c *Config) Clone() *Config {
+	clone := *c
+	clone.Server.Listeners = append([]Listener(nil), c.Server.Listeners...)
+	clone.Server.ProxyProtocol.TrustedCIDRs = append([]string(nil), c.Server.ProxyProtocol.TrustedCIDRs...)
+	clone.Server.TrustedProxies = append([]string(nil), c.Server.TrustedProxies...)
+	clone.Server.TLS.Certificates = append([]Certificate(nil), c.Server.TLS.Certificates...)
+	clone.Server.TLS.CipherSuites = append([]string(nil), c.Server.TLS.CipherSuites...)
+	clone.Server.TLS.ACME.Hosts = append([]string(nil), c.Server.TLS.ACME.Hosts...)
+	clone.Logging.Outputs = append([]logging.OutputConfig(nil), c.Logging.Outputs...)
+	clone.Logging.AccessLog.Exclude = append([]string(nil), c.Logging.AccessLog.Exclude...)
+	clone.Logging.AccessLog.Sampling = append([]logging.SamplingRule(nil), c.Logging.AccessLog.Sampling...)
+	clone.Logging.Redaction.Headers = append([]string(nil), c.Logging.Redaction.Headers...)
+	clone.Logging.Redaction.QueryParams = append([]string(nil), c.Logging.Redaction.QueryParams...)
+	clone.Logging.Redaction.BodyFields = append([]string(nil), c.Logging.Redaction.BodyFields...)
+	clone.Logging.Redaction.BodyPatterns = append([]string(nil), c.Logging.Redaction.BodyPatterns...)
+	clone.Logging.Redaction.Patterns = append([]string(nil), c.Logging.Redaction.Patterns...)
+	clone.Metrics.Labels = maps.Clone(c.Metrics.Labels)
+	clone.Tracing.Headers = maps.Clone(c.Tracing.Headers)
+	clone.Tracing.Propagators = append([]string(nil), c.Tracing.Propagators...)
+	clone.Admin.Tokens = append([]AdminToken(nil), c.Admin.Tokens...)
+	clone.Upstreams = append([]Upstream(nil), c.Upstreams...)
+	clone.Services = append([]Service(nil), c.Services...)
+	for i := range clone.Services {
+		clone.Services[i].Tags = append([]string(nil), c.Services[i].Tags...)
+	}
+	clone.Routes = append([]Route(nil), c.Routes...)
+	return &clone
+}
+
+// Redacted returns a copy of the configuration with secrets masked, suitable
+// for display
+func (c *Config) Redacted() *Config {
+	clone := c.Clone()
+	for i := range clone.Admin.Tokens {
+		clone.Admin.Tokens[i].Token = "******"
+	}
+	if clone.Discovery.Consul.Token != "" {
+		clone.Discovery.Consul.Token = "******"
+	}
+	for name := range clone.Tracing.Headers {
+		clone.Tracing.Headers[name] = "******"
+	}
+	return clone
+}
+
+// LoadConfig loads the configuration from a file, choosing the decoder from
+// the file extension
 func LoadConfig(filename string) (*Config, error) {
-	configFile, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+	return LoadConfigWithFormat(filename, "")
+}
+
+// LoadConfigWithFormat loads the configuration from a file using the given
+// format. An empty format falls back to detection by file extension.
+func LoadConfigWithFormat(filename string, format Format) (*Config, error) {
+	if format == "" {
+		format = FormatFromPath(filename)
 	}
-	defer configFile.Close()
 
-	var config Config
-	decoder := yaml.NewDecoder(configFile)
-	err = decoder.Decode(&config)
+	configFile, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
+	defer configFile.Close()
 
-	return &config, nil
+	return Decode(configFile, format)
 }
@@ -1,10 +1,18 @@
 package config
 
 import (
+	"bytes"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/leo-andrei/api-gateway/internal/logging"
 )
 
 func TestLoadConfig_ValidFile(t *testing.T) {
@@ -14,6 +22,12 @@ func TestLoadConfig_ValidFile(t *testing.T) {
 	assert.Equal(t, 8080, cfg.Server.Port)
 	assert.Equal(t, "info", cfg.Logging.Level)
 	assert.Equal(t, "json", cfg.Logging.Format)
+	assert.Equal(t, 5, cfg.Logging.BatchSize)
+	assert.Equal(t, 2*time.Second, cfg.Logging.FlushInterval.Std())
+	assert.Equal(t, []logging.OutputConfig{
+		{Type: logging.OutputStdout},
+		{Type: logging.OutputFile, Path: "/var/log/api-gateway/gateway.log", Level: "warn", Format: "text", MaxSizeMB: 50},
+	}, cfg.Logging.Outputs)
 }
 
 func TestLoadConfig_InvalidFile(t *testing.T) {
@@ -27,3 +41,421 @@ func TestLoadConfig_MissingFile(t *testing.T) {
 	_, err := LoadConfig(configPath)
 	assert.Error(t, err)
 }
+
+func TestLoadConfig_FormatByExtension(t *testing.T) {
+	for _, configPath := range []string{
+		"testdata/valid_config.json",
+		"testdata/valid_config.toml",
+	} {
+		t.Run(configPath, func(t *testing.T) {
+			cfg, err := LoadConfig(configPath)
+			require.NoError(t, err)
+			assert.Equal(t, 8080, cfg.Server.Port)
+			assert.Equal(t, "info", cfg.Logging.Level)
+			require.Len(t, cfg.Routes, 1)
+			assert.Equal(t, "/api/users", cfg.Routes[0].Path)
+			assert.Equal(t, "http://user-service:8081/users", cfg.Routes[0].TargetURL)
+			assert.True(t, cfg.Routes[0].RequireAuth)
+		})
+	}
+}
+
+func TestLoadConfigWithFormat_ExplicitFormat(t *testing.T) {
+	_, err := LoadConfigWithFormat("testdata/valid_config.json", FormatTOML)
+	assert.Error(t, err)
+
+	cfg, err := LoadConfigWithFormat("testdata/valid_config.json", FormatJSON)
+	require.NoError(t, err)
+	assert.Len(t, cfg.Routes, 1)
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	cfg, err := Decode(strings.NewReader(`{"routes": [{"path": "/a", "targetUrl": "http://a"}]}`), FormatJSON)
+	require.NoError(t, err)
+	assert.Equal(t, 8080, cfg.Server.Port)
+	assert.Equal(t, "info", cfg.Logging.Level)
+	assert.Equal(t, "GET", cfg.Routes[0].Method)
+}
+
+func TestLoadConfig_InvalidRoute(t *testing.T) {
+	_, err := LoadConfig("testdata/invalid_route.yaml")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must start with /")
+}
+
+func TestLoadConfig_DuplicateRoute(t *testing.T) {
+	input := `
+routes:
+  - path: /a
+    targetUrl: http://a
+  - path: /a
+    targetUrl: http://b
+    method: get
+`
+	_, err := Decode(strings.NewReader(input), FormatYAML)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicates route 0")
+}
+
+func TestConvert_RoundTrip(t *testing.T) {
+	for _, format := range []Format{FormatYAML, FormatJSON, FormatTOML} {
+		t.Run(string(format), func(t *testing.T) {
+			original, err := LoadConfig("testdata/valid_config.yaml")
+			require.NoError(t, err)
+
+			source, err := os.Open("testdata/valid_config.toml")
+			require.NoError(t, err)
+			defer source.Close()
+
+			var converted bytes.Buffer
+			require.NoError(t, Convert(source, FormatTOML, &converted, format))
+
+			cfg, err := Decode(&converted, format)
+			require.NoError(t, err)
+			assert.Equal(t, original.Server, cfg.Server)
+			assert.Equal(t, original.Logging, cfg.Logging)
+			assert.Len(t, cfg.Routes, 1)
+		})
+	}
+}
+
+func TestSaveConfig(t *testing.T) {
+	cfg, err := LoadConfig("testdata/valid_config.toml")
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, SaveConfig(cfg, path, ""))
+
+	saved, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, cfg, saved)
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("YML")
+	require.NoError(t, err)
+	assert.Equal(t, FormatYAML, format)
+
+	_, err = ParseFormat("ini")
+	assert.Error(t, err)
+
+	assert.Equal(t, FormatYAML, FormatFromPath("config"))
+	assert.Equal(t, FormatTOML, FormatFromPath("/etc/gateway/config.toml"))
+}
+
+func TestLoadConfig_Services(t *testing.T) {
+	input := `
+discovery:
+  file:
+    path: services.yaml
+    interval: 2s
+  dns:
+    ttl: 1m
+services:
+  - name: user-service
+    provider: dns
+    host: user-service.internal
+    port: 8081
+  - name: order-service
+    provider: file
+routes:
+  - path: /api/users
+    service: user-service
+    targetUrl: /users
+`
+	cfg, err := Decode(strings.NewReader(input), FormatYAML)
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Second, cfg.Discovery.File.Interval.Std())
+	assert.Equal(t, time.Minute, cfg.Discovery.DNS.TTL.Std())
+	assert.Equal(t, "http", cfg.Services[0].Scheme)
+
+	var converted bytes.Buffer
+	require.NoError(t, Encode(&converted, cfg, FormatTOML))
+	roundTrip, err := Decode(&converted, FormatTOML)
+	require.NoError(t, err)
+	assert.Equal(t, cfg, roundTrip)
+}
+
+func TestLoadConfig_InvalidServices(t *testing.T) {
+	input := `
+services:
+  - name: a
+    provider: dns
+    host: a.internal
+  - name: b
+    provider: file
+  - name: c
+    provider: zookeeper
+  - name: d
+    provider: dns
+    srv: _http._tcp.d.internal
+    tags: [primary]
+routes:
+  - path: /api/users
+    service: missing
+    targetUrl: /users
+`
+	_, err := Decode(strings.NewReader(input), FormatYAML)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `needs a valid port for host "a.internal"`)
+	assert.Contains(t, err.Error(), "discovery.file.path is required")
+	assert.Contains(t, err.Error(), `unknown provider "zookeeper"`)
+	assert.Contains(t, err.Error(), "only supported by the consul provider")
+	assert.Contains(t, err.Error(), `unknown service "missing"`)
+}
+
+func TestLoadConfig_TLS(t *testing.T) {
+	input := `
+server:
+  port: 8080
+  tls:
+    port: 8443
+    minVersion: "1.3"
+    cipherSuites: [TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256]
+    reloadInterval: 30s
+    certificates:
+      - certFile: a.crt
+        keyFile: a.key
+`
+	cfg, err := Decode(strings.NewReader(input), FormatYAML)
+	require.NoError(t, err)
+	assert.True(t, cfg.Server.TLS.Enabled())
+	version, err := cfg.Server.TLS.TLSVersion()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), version)
+	ids, err := cfg.Server.TLS.CipherSuiteIDs()
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, ids)
+
+	invalid := `
+server:
+  port: 8443
+  tls:
+    port: 8443
+    minVersion: "1.0"
+    cipherSuites: [TLS_RSA_WITH_RC4_128_SHA]
+`
+	_, err = Decode(strings.NewReader(invalid), FormatYAML)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "port 8443 is already used by the server")
+	assert.Contains(t, err.Error(), "at least one certificate or ACME host is required")
+	assert.Contains(t, err.Error(), `unsupported minVersion "1.0"`)
+	assert.Contains(t, err.Error(), `unsupported cipher suite "TLS_RSA_WITH_RC4_128_SHA"`)
+}
+
+func TestLoadConfig_ACME(t *testing.T) {
+	input := `
+server:
+  tls:
+    port: 8443
+    acme:
+      hosts: [api.example.com]
+      email: ops@example.com
+      renewBefore: 720h
+`
+	cfg, err := Decode(strings.NewReader(input), FormatYAML)
+	require.NoError(t, err)
+	assert.True(t, cfg.Server.TLS.ACME.Enabled())
+	assert.Equal(t, 720*time.Hour, cfg.Server.TLS.ACME.RenewBefore.Std())
+
+	clone := cfg.Clone()
+	clone.Server.TLS.ACME.Hosts[0] = "changed.example.com"
+	assert.Equal(t, "api.example.com", cfg.Server.TLS.ACME.Hosts[0])
+
+	invalid := `
+server:
+  tls:
+    port: 8443
+    acme:
+      hosts: ["api.example.com:443"]
+`
+	_, err = Decode(strings.NewReader(invalid), FormatYAML)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid host 0 "api.example.com:443"`)
+}
+
+func TestValidate_RouteProtocol(t *testing.T) {
+	input := `
+upstreams:
+  - name: plain
+    url: http://backend:8080
+services:
+  - name: secure
+    provider: dns
+    scheme: https
+    host: backend.internal
+    port: 8443
+routes:
+  - path: /a
+    upstream: plain
+    targetUrl: /a
+    protocol: h2c
+  - path: /b
+    targetUrl: http://backend:8080/b
+    protocol: h2
+  - path: /c
+    service: secure
+    targetUrl: /c
+    protocol: h2c
+  - path: /d
+    targetUrl: http://backend:8080/d
+    protocol: spdy
+`
+	_, err := Decode(strings.NewReader(input), FormatYAML)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "route 0")
+	assert.Contains(t, err.Error(), "route 1: protocol h2 requires an https target")
+	assert.Contains(t, err.Error(), "route 2: protocol h2c requires an http target")
+	assert.Contains(t, err.Error(), `route 3: unsupported protocol "spdy"`)
+}
+
+func TestLoadConfig_HTTP3(t *testing.T) {
+	input := `
+server:
+  tls:
+    port: 8443
+    certificates:
+      - certFile: a.crt
+        keyFile: a.key
+    http3:
+      port: 8443
+      maxAge: 1h
+`
+	cfg, err := Decode(strings.NewReader(input), FormatYAML)
+	require.NoError(t, err)
+	assert.True(t, cfg.Server.TLS.HTTP3.Enabled())
+	assert.Equal(t, time.Hour, cfg.Server.TLS.HTTP3.MaxAge.Std())
+
+	invalid := `
+server:
+  tls:
+    http3:
+      port: 8443
+`
+	_, err = Decode(strings.NewReader(invalid), FormatYAML)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server.tls.http3: requires the TLS listener")
+}
+
+func TestLoadConfig_ServerTuning(t *testing.T) {
+	input := `
+server:
+  port: 8080
+  address: 127.0.0.1
+  readHeaderTimeout: 5s
+  maxHeaderBytes: 65536
+  maxConnections: 500
+  shutdown:
+    drainDelay: 10s
+    timeout: 20s
+  listeners:
+    - address: 127.0.0.1:9090
+    - network: unix
+      address: /run/gateway.sock
+`
+	cfg, err := Decode(strings.NewReader(input), FormatYAML)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, cfg.Server.ReadHeaderTimeout.Std())
+	assert.Equal(t, 10*time.Second, cfg.Server.Shutdown.DrainDelay.Std())
+	assert.Equal(t, 20*time.Second, cfg.Server.Shutdown.Timeout.Std())
+	assert.Equal(t, []Listener{
+		{Network: NetworkTCP, Address: "127.0.0.1:9090"},
+		{Network: NetworkUnix, Address: "/run/gateway.sock"},
+	}, cfg.Server.Listeners)
+
+	invalid := `
+server:
+  address: localhost
+  writeTimeout: -1s
+  maxConnections: -1
+  shutdown:
+    timeout: -5s
+  listeners:
+    - address: 9090
+    - network: udp
+      address: 127.0.0.1:9090
+    - network: unix
+      address: /run/gateway.sock
+    - network: unix
+      address: /run/gateway.sock
+`
+	_, err = Decode(strings.NewReader(invalid), FormatYAML)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `address "localhost" must be an IP address`)
+	assert.Contains(t, err.Error(), "writeTimeout must not be negative")
+	assert.Contains(t, err.Error(), "maxConnections must not be negative")
+	assert.Contains(t, err.Error(), "shutdown.timeout must not be negative")
+	assert.Contains(t, err.Error(), `server.listeners 0: address "9090" must be host:port`)
+	assert.Contains(t, err.Error(), `server.listeners 1: unsupported network "udp"`)
+	assert.Contains(t, err.Error(), "server.listeners 3: duplicates listener 2")
+}
+
+func TestLoadConfig_ProxyProtocol(t *testing.T) {
+	input := `
+server:
+  proxyProtocol:
+    enabled: true
+    trustedCidrs: [10.0.0.0/8, 192.168.1.10]
+  listeners:
+    - network: unix
+      address: /run/gateway.sock
+      proxyProtocol: true
+`
+	cfg, err := Decode(strings.NewReader(input), FormatYAML)
+	require.NoError(t, err)
+	assert.True(t, cfg.Server.ProxyProtocol.Enabled)
+	assert.True(t, cfg.Server.Listeners[0].ProxyProtocol)
+
+	ipNet, err := ParseCIDR("192.168.1.10")
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.1.10/32", ipNet.String())
+
+	invalid := `
+server:
+  listeners:
+    - address: 127.0.0.1:9090
+      proxyProtocol: true
+`
+	_, err = Decode(strings.NewReader(invalid), FormatYAML)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server.proxyProtocol: trustedCidrs are required")
+
+	invalid = `
+server:
+  proxyProtocol:
+    enabled: true
+    trustedCidrs: [10.0.0.0/33]
+`
+	_, err = Decode(strings.NewReader(invalid), FormatYAML)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `trusted CIDR 0: invalid CIDR "10.0.0.0/33"`)
+}
+
+func TestValidate_TrustedProxies(t *testing.T) {
+	cfg := &Config{Server: ServerConfig{Port: 8080, TrustedProxies: []string{"10.0.0.0/8", "::1", "proxy.internal"}}}
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `server.trustedProxies 2: invalid IP address "proxy.internal"`)
+	assert.NotContains(t, err.Error(), "trustedProxies 0")
+	assert.NotContains(t, err.Error(), "trustedProxies 1")
+}
+
+func TestValidate_Health(t *testing.T) {
+	cfg := &Config{Server: ServerConfig{Port: 8080}, Health: HealthConfig{AdminPort: true, Timeout: Duration(-time.Second)}}
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "health: adminPort requires the admin API")
+	assert.Contains(t, err.Error(), "health: timeout must not be negative")
+}
+
+func TestValidate_Logging(t *testing.T) {
+	cfg := &Config{Server: ServerConfig{Port: 8080}, Logging: logging.LoggingConfig{
+		Level:   "verbose",
+		Outputs: []logging.OutputConfig{{Type: "kafka"}, {Type: logging.OutputFile, Format: "xml"}},
+	}}
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `logging: unsupported level "verbose"`)
+	assert.Contains(t, err.Error(), `outputs[0]: unsupported type "kafka"`)
+	assert.Contains(t, err.Error(), `outputs[1]: unsupported format "xml"`)
+}
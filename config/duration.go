@@ -0,0 +1,9 @@
+package config
+
+import (
+	"github.com/leo-andrei/api-gateway/internal/duration"
+)
+
+// Duration is a time.Duration written as a string such as "30s" or "5m" in
+// every configuration format
+type Duration = duration.Duration
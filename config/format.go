@@ -0,0 +1,133 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Format identifies the encoding of a configuration file
+type Format string
+
+const (
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+	FormatTOML Format = "toml"
+)
+
+// ParseFormat converts a user supplied format name into a Format
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "yaml", "yml":
+		return FormatYAML, nil
+	case "json":
+		return FormatJSON, nil
+	case "toml":
+		return FormatTOML, nil
+	default:
+		return "", fmt.Errorf("unsupported config format %q", name)
+	}
+}
+
+// FormatFromPath detects the format from a file extension, defaulting to YAML
+func FormatFromPath(filename string) Format {
+	format, err := ParseFormat(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if err != nil {
+		return FormatYAML
+	}
+	return format
+}
+
+// Decode reads a configuration in the given format, applies defaults and
+// validates the result
+func Decode(r io.Reader, format Format) (*Config, error) {
+	var config Config
+	var err error
+
+	switch format {
+	case FormatYAML, "":
+		err = yaml.NewDecoder(r).Decode(&config)
+	case FormatJSON:
+		err = json.NewDecoder(r).Decode(&config)
+	case FormatTOML:
+		_, err = toml.NewDecoder(r).Decode(&config)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config.applyDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, &ValidationError{Err: err}
+	}
+
+	return &config, nil
+}
+
+// Encode writes the configuration in the given format
+func Encode(w io.Writer, config *Config, format Format) error {
+	switch format {
+	case FormatYAML, "":
+		return yaml.NewEncoder(w).Encode(config)
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(config)
+	case FormatTOML:
+		return toml.NewEncoder(w).Encode(config)
+	default:
+		return fmt.Errorf("unsupported config format %q", format)
+	}
+}
+
+// Convert decodes a configuration in one format and re-encodes it in another.
+// The configuration is validated on the way through.
+func Convert(r io.Reader, from Format, w io.Writer, to Format) error {
+	config, err := Decode(r, from)
+	if err != nil {
+		return err
+	}
+	return Encode(w, config, to)
+}
+
+// SaveConfig writes the configuration to a file. An empty format falls back
+// to detection by file extension. The file is replaced atomically so readers
+// never observe a partially written configuration.
+func SaveConfig(config *Config, filename string, format Format) error {
+	if format == "" {
+		format = FormatFromPath(filename)
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := tmpFile.Chmod(mode); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err := Encode(tmpFile, config, format); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), filename)
+}
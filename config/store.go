@@ -0,0 +1,261 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const defaultHistorySize = 10
+
+var (
+	// ErrVersionConflict is returned when an update is based on a stale version
+	ErrVersionConflict = errors.New("config version conflict")
+	// ErrVersionNotFound is returned for versions that are not in the history
+	ErrVersionNotFound = errors.New("config version not found")
+)
+
+// Sources of applied configurations recorded in the history
+const (
+	SourceFile     = "file"
+	SourceAdmin    = "admin"
+	SourceRollback = "rollback"
+)
+
+// Origin describes where a configuration change came from
+type Origin struct {
+	Source string
+	Actor  string
+}
+
+// Revision is an applied configuration kept in the store history
+type Revision struct {
+	Version   uint64    `json:"version"`
+	AppliedAt time.Time `json:"appliedAt"`
+	Source    string    `json:"source"`
+	Actor     string    `json:"actor,omitempty"`
+	Diff      string    `json:"diff"`
+	Config    *Config   `json:"-"`
+}
+
+// Store holds the active configuration together with a version number that
+// increases on every change. Changes are validated like a file load and
+// persisted back to the file the configuration was read from. The file is
+// rewritten from the active configuration, so defaults are written out,
+// values such as route methods are normalized and comments are lost. The
+// last applied configurations are kept so that a bad change can be rolled
+// back.
+type Store struct {
+	changeMu    sync.Mutex // Serializes changes so listeners see them in order
+	mu          sync.RWMutex
+	filename    string
+	format      Format
+	config      *Config
+	version     uint64
+	history     []Revision
+	historySize int
+	listeners   []func(*Config)
+}
+
+// NewStore creates a store for a configuration loaded from filename. An empty
+// filename keeps changes in memory only.
+func NewStore(filename string, format Format, config *Config) *Store {
+	historySize := config.Admin.HistorySize
+	if historySize <= 0 {
+		historySize = defaultHistorySize
+	}
+
+	s := &Store{
+		filename:    filename,
+		format:      format,
+		historySize: historySize,
+	}
+	s.record(config, Origin{Source: SourceFile, Actor: filename})
+	return s
+}
+
+// Current returns the active configuration and its version. The returned
+// configuration must not be modified.
+func (s *Store) Current() (*Config, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config, s.version
+}
+
+// History returns the retained revisions, newest first
+func (s *Store) History() []Revision {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := make([]Revision, len(s.history))
+	for i, revision := range s.history {
+		history[len(s.history)-1-i] = revision
+	}
+	return history
+}
+
+// Revision returns a retained revision by version
+func (s *Store) Revision(version uint64) (Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.revision(version)
+}
+
+func (s *Store) revision(version uint64) (Revision, error) {
+	for _, revision := range s.history {
+		if revision.Version == version {
+			return revision, nil
+		}
+	}
+	return Revision{}, fmt.Errorf("%w: %d", ErrVersionNotFound, version)
+}
+
+// Subscribe registers a function called with every newly applied
+// configuration. Listeners are called after the store is unlocked, so they can
+// read it, but they must not change it.
+func (s *Store) Subscribe(fn func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Update applies mutate to a copy of the active configuration. A non-zero
+// expected version must match the active version, otherwise ErrVersionConflict
+// is returned. The result is validated and persisted before it becomes active.
+func (s *Store) Update(expected uint64, origin Origin, mutate func(*Config) error) (*Config, uint64, error) {
+	return s.change(origin, true, func() (*Config, error) {
+		if err := s.checkVersion(expected); err != nil {
+			return nil, err
+		}
+		next := s.config.Clone()
+		if err := mutate(next); err != nil {
+			return nil, err
+		}
+		return next, nil
+	})
+}
+
+// Rollback re-applies the routes, services and upstreams of a retained
+// revision as a new version. Every other setting, admin tokens included, is
+// kept from the active configuration so that a rollback cannot bring back a
+// revoked token or an old TLS or logging setup. The expected version is
+// checked like in Update.
+func (s *Store) Rollback(expected, version uint64, origin Origin) (*Config, uint64, error) {
+	return s.change(origin, true, func() (*Config, error) {
+		if err := s.checkVersion(expected); err != nil {
+			return nil, err
+		}
+		revision, err := s.revision(version)
+		if err != nil {
+			return nil, err
+		}
+		previous := revision.Config.Clone()
+		next := s.config.Clone()
+		next.Upstreams = previous.Upstreams
+		next.Services = previous.Services
+		next.Routes = previous.Routes
+		return next, nil
+	})
+}
+
+// Reload reads the configuration file again and applies it without writing it
+// back
+func (s *Store) Reload(origin Origin) (*Config, uint64, error) {
+	if s.filename == "" {
+		return nil, 0, errors.New("config store has no file to reload")
+	}
+	next, err := LoadConfigWithFormat(s.filename, s.format)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.change(origin, false, func() (*Config, error) {
+		return next, nil
+	})
+}
+
+// change applies the configuration returned by prepare, which runs under the
+// write lock, and then notifies the listeners once the lock is released
+func (s *Store) change(origin Origin, persist bool, prepare func() (*Config, error)) (*Config, uint64, error) {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+
+	s.mu.Lock()
+	next, err := prepare()
+	var version uint64
+	if err == nil {
+		version, err = s.apply(next, origin, persist)
+	}
+	listeners := slices.Clone(s.listeners)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, fn := range listeners {
+		fn(next)
+	}
+	return next, version, nil
+}
+
+func (s *Store) checkVersion(expected uint64) error {
+	if expected != 0 && expected != s.version {
+		return fmt.Errorf("%w: expected version %d, current version is %d", ErrVersionConflict, expected, s.version)
+	}
+	return nil
+}
+
+// apply validates, optionally persists and activates a configuration, and
+// returns its version. The caller must hold the write lock.
+func (s *Store) apply(next *Config, origin Origin, persist bool) (uint64, error) {
+	next.applyDefaults()
+	if err := next.Validate(); err != nil {
+		return 0, &ValidationError{Err: err}
+	}
+
+	if persist && s.filename != "" {
+		if err := SaveConfig(next, s.filename, s.format); err != nil {
+			return 0, fmt.Errorf("persisting config: %w", err)
+		}
+	}
+
+	s.record(next, origin)
+	return s.version, nil
+}
+
+// record makes config the active configuration and appends it to the history
+func (s *Store) record(config *Config, origin Origin) {
+	diff := ""
+	if s.config != nil {
+		diff = Diff(s.config, config)
+	}
+
+	s.config = config
+	s.version++
+	s.history = append(s.history, Revision{
+		Version:   s.version,
+		AppliedAt: time.Now(),
+		Source:    origin.Source,
+		Actor:     origin.Actor,
+		Diff:      diff,
+		Config:    config,
+	})
+	if len(s.history) > s.historySize {
+		s.history = append([]Revision(nil), s.history[len(s.history)-s.historySize:]...)
+	}
+}
+
+// Diff returns a line based diff between the YAML encodings of two
+// configurations. Admin tokens are masked.
+func Diff(from, to *Config) string {
+	return diffLines(encodeForDiff(from), encodeForDiff(to))
+}
+
+func encodeForDiff(config *Config) []string {
+	config = config.Redacted()
+	var buf bytes.Buffer
+	Encode(&buf, config, FormatYAML)
+	return splitLines(buf.String())
+}
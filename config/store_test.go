@@ -0,0 +1,249 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T) (*Store, string) {
+	cfg, err := LoadConfig("testdata/valid_config.json")
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, SaveConfig(cfg, path, ""))
+	return NewStore(path, "", cfg), path
+}
+
+func TestStore_UpdatePersistsAndNotifies(t *testing.T) {
+	store, path := newTestStore(t)
+
+	var notified *Config
+	store.Subscribe(func(cfg *Config) { notified = cfg })
+
+	updated, version, err := store.Update(1, Origin{Source: SourceAdmin, Actor: "test"}, func(cfg *Config) error {
+		cfg.Routes = append(cfg.Routes, Route{Path: "/api/orders", TargetURL: "http://order-service:8083/orders", Method: "post"})
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), version)
+	assert.Equal(t, updated, notified)
+	assert.Equal(t, "post-api-orders", updated.Routes[1].Name)
+	assert.Equal(t, "POST", updated.Routes[1].Method)
+
+	saved, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, updated, saved)
+}
+
+func TestStore_PersistsNormalizedConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	original := `# Local development
+routes:
+  - path: /api/users # user service
+    targetUrl: http://user-service:8081/users
+    method: get
+`
+	require.NoError(t, os.WriteFile(path, []byte(original), 0o644))
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	store := NewStore(path, "", cfg)
+
+	updated, _, err := store.Update(0, Origin{Source: SourceAdmin}, func(cfg *Config) error {
+		cfg.Routes[0].RequireAuth = true
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The whole active configuration is written back: defaults are filled
+	// in, values are normalized and comments are not kept
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	saved := string(data)
+	assert.Contains(t, saved, "port: 8080")
+	assert.Contains(t, saved, "name: get-api-users")
+	assert.Contains(t, saved, "method: GET")
+	assert.NotContains(t, saved, "#")
+
+	reloaded, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, updated, reloaded)
+}
+
+func TestStore_ListenersCanReadStore(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	var version uint64
+	var history []Revision
+	store.Subscribe(func(*Config) {
+		_, version = store.Current()
+		history = store.History()
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := store.Update(0, Origin{Source: SourceAdmin}, func(cfg *Config) error { return nil })
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener deadlocked reading the store")
+	}
+	assert.Equal(t, uint64(2), version)
+	assert.Len(t, history, 2)
+}
+
+func TestStore_UpdateVersionConflict(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	_, _, err := store.Update(5, Origin{}, func(cfg *Config) error { return nil })
+	assert.ErrorIs(t, err, ErrVersionConflict)
+
+	_, version := store.Current()
+	assert.Equal(t, uint64(1), version)
+}
+
+func TestStore_UpdateRejectsInvalidConfig(t *testing.T) {
+	store, path := newTestStore(t)
+	before, _ := store.Current()
+
+	_, _, err := store.Update(0, Origin{}, func(cfg *Config) error {
+		cfg.Routes[0].Upstream = "missing"
+		cfg.Routes[0].TargetURL = "/users"
+		return nil
+	})
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Contains(t, err.Error(), `unknown upstream "missing"`)
+
+	current, version := store.Current()
+	assert.Equal(t, uint64(1), version)
+	assert.Equal(t, before, current)
+	assert.Equal(t, "http://user-service:8081/users", current.Routes[0].TargetURL)
+
+	saved, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, before, saved)
+}
+
+func TestConfig_TargetURL(t *testing.T) {
+	cfg := &Config{Upstreams: []Upstream{{Name: "users", URL: "http://user-service:8081/"}}}
+
+	assert.Equal(t, "http://user-service:8081/users", cfg.TargetURL(Route{Upstream: "users", TargetURL: "/users"}))
+	assert.Equal(t, "http://user-service:8081/", cfg.TargetURL(Route{Upstream: "users"}))
+	assert.Equal(t, "http://other/x", cfg.TargetURL(Route{TargetURL: "http://other/x"}))
+}
+
+func TestStore_HistoryAndRollback(t *testing.T) {
+	store, path := newTestStore(t)
+	original, _ := store.Current()
+
+	_, _, err := store.Update(0, Origin{Source: SourceAdmin, Actor: "alice"}, func(cfg *Config) error {
+		cfg.Routes[0].TargetURL = "http://user-service:9999/users"
+		return nil
+	})
+	require.NoError(t, err)
+
+	history := store.History()
+	require.Len(t, history, 2)
+	assert.Equal(t, uint64(2), history[0].Version)
+	assert.Equal(t, SourceAdmin, history[0].Source)
+	assert.Equal(t, "alice", history[0].Actor)
+	assert.Contains(t, history[0].Diff, "-  targetUrl: http://user-service:8081/users")
+	assert.Contains(t, history[0].Diff, "+  targetUrl: http://user-service:9999/users")
+	assert.Equal(t, SourceFile, history[1].Source)
+
+	rolledBack, version, err := store.Rollback(2, 1, Origin{Source: SourceRollback, Actor: "bob"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), version)
+	assert.Equal(t, original.Routes, rolledBack.Routes)
+
+	saved, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, original.Routes, saved.Routes)
+
+	_, _, err = store.Rollback(0, 42, Origin{})
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+	_, _, err = store.Rollback(1, 2, Origin{})
+	assert.ErrorIs(t, err, ErrVersionConflict)
+}
+
+func TestStore_RollbackKeepsAdminSettings(t *testing.T) {
+	store, _ := newTestStore(t)
+	_, _, err := store.Update(0, Origin{Source: SourceAdmin}, func(cfg *Config) error {
+		cfg.Admin.Tokens = []AdminToken{{Name: "ops", Token: "leaked"}}
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, _, err = store.Update(0, Origin{Source: SourceAdmin}, func(cfg *Config) error {
+		cfg.Admin.Tokens = []AdminToken{{Name: "ops", Token: "rotated"}}
+		cfg.Logging.Level = "debug"
+		cfg.Routes[0].TargetURL = "http://user-service:9999/users"
+		return nil
+	})
+	require.NoError(t, err)
+
+	rolledBack, _, err := store.Rollback(0, 2, Origin{Source: SourceRollback})
+	require.NoError(t, err)
+	assert.Equal(t, "http://user-service:8081/users", rolledBack.Routes[0].TargetURL)
+	assert.Equal(t, []AdminToken{{Name: "ops", Token: "rotated"}}, rolledBack.Admin.Tokens, "the revoked token is not restored")
+	assert.Equal(t, "debug", rolledBack.Logging.Level)
+}
+
+func TestStore_HistoryIsBounded(t *testing.T) {
+	cfg, err := LoadConfig("testdata/valid_config.json")
+	require.NoError(t, err)
+	cfg.Admin.HistorySize = 3
+	store := NewStore("", "", cfg)
+
+	for i := 0; i < 5; i++ {
+		_, _, err := store.Update(0, Origin{Source: SourceAdmin}, func(cfg *Config) error {
+			cfg.Server.Port++
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	history := store.History()
+	require.Len(t, history, 3)
+	assert.Equal(t, uint64(6), history[0].Version)
+	assert.Equal(t, uint64(4), history[2].Version)
+
+	_, err = store.Revision(1)
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+}
+
+func TestStore_Reload(t *testing.T) {
+	store, path := newTestStore(t)
+
+	cfg, _ := store.Current()
+	edited := cfg.Clone()
+	edited.Server.Port = 9090
+	require.NoError(t, SaveConfig(edited, path, ""))
+
+	reloaded, version, err := store.Reload(Origin{Source: SourceFile, Actor: "SIGHUP"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), version)
+	assert.Equal(t, 9090, reloaded.Server.Port)
+	assert.Equal(t, "SIGHUP", store.History()[0].Actor)
+}
+
+func TestDiff(t *testing.T) {
+	from := &Config{Admin: AdminConfig{Tokens: []AdminToken{{Name: "ops", Token: "secret"}}}}
+	to := from.Clone()
+	to.Server.Port = 9090
+
+	diff := Diff(from, to)
+	assert.Contains(t, diff, "-  port: 0")
+	assert.Contains(t, diff, "+  port: 9090")
+	assert.NotContains(t, diff, "secret")
+	assert.Empty(t, Diff(from, from))
+}
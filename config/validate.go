@@ -0,0 +1,453 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultPort = 8080
+
+var validMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// ValidationError reports a configuration that failed validation
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// applyDefaults fills in values that were left empty in the configuration file
+func (c *Config) applyDefaults() {
+	if c.Server.Port == 0 {
+		c.Server.Port = defaultPort
+	}
+	for i := range c.Server.Listeners {
+		if c.Server.Listeners[i].Network == "" {
+			c.Server.Listeners[i].Network = NetworkTCP
+		}
+	}
+	if c.Logging.Level == "" {
+		c.Logging.Level = "info"
+	}
+	for i := range c.Services {
+		if c.Services[i].Scheme == "" {
+			c.Services[i].Scheme = "http"
+		}
+	}
+	for i := range c.Routes {
+		c.Routes[i].ApplyDefaults()
+	}
+}
+
+// ApplyDefaults fills in the method and name of a route when they are empty
+func (r *Route) ApplyDefaults() {
+	if r.Method == "" {
+		r.Method = http.MethodGet
+	}
+	r.Method = strings.ToUpper(r.Method)
+	if r.Name == "" {
+		r.Name = DefaultRouteName(r.Method, r.Path)
+	}
+}
+
+// DefaultRouteName derives a route name from its method and path, e.g.
+// "get-api-users" for GET /api/users
+func DefaultRouteName(method, path string) string {
+	name := strings.ToLower(method)
+	for _, segment := range strings.Split(path, "/") {
+		segment = strings.Trim(segment, "{}")
+		if segment != "" {
+			name += "-" + strings.ToLower(segment)
+		}
+	}
+	return name
+}
+
+// Validate checks the configuration for errors, reporting all of them at once
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.Port < 0 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server: invalid port %d", c.Server.Port))
+	}
+	errs = append(errs, c.Server.validate()...)
+	errs = append(errs, c.Server.TLS.validate(c.Server.Port)...)
+	if c.Admin.Port != 0 && c.Admin.Port == c.Server.TLS.Port {
+		errs = append(errs, fmt.Errorf("admin: port %d is already used by the TLS listener", c.Admin.Port))
+	}
+	if c.Admin.Port < 0 || c.Admin.Port > 65535 {
+		errs = append(errs, fmt.Errorf("admin: invalid port %d", c.Admin.Port))
+	}
+	if c.Admin.Port != 0 && c.Admin.Port == c.Server.Port {
+		errs = append(errs, fmt.Errorf("admin: port %d is already used by the server", c.Admin.Port))
+	}
+	if c.Admin.Port != 0 && len(c.Admin.Tokens) == 0 {
+		errs = append(errs, errors.New("admin: at least one token is required"))
+	}
+	if c.Health.AdminPort && c.Admin.Port == 0 {
+		errs = append(errs, errors.New("health: adminPort requires the admin API"))
+	}
+	if c.Health.Timeout < 0 {
+		errs = append(errs, errors.New("health: timeout must not be negative"))
+	}
+	if err := c.Logging.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("logging: %w", err))
+	}
+	if err := c.Metrics.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("metrics: %w", err))
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("tracing: %w", err))
+	}
+	if err := c.RequestID.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("requestId: %w", err))
+	}
+	if c.Admin.HistorySize < 0 {
+		errs = append(errs, fmt.Errorf("admin: invalid historySize %d", c.Admin.HistorySize))
+	}
+	for i, token := range c.Admin.Tokens {
+		if token.Name == "" || token.Token == "" {
+			errs = append(errs, fmt.Errorf("admin: token %d needs a name and a token", i))
+		}
+	}
+
+	upstreams := make(map[string]bool)
+	for i, upstream := range c.Upstreams {
+		if err := upstream.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("upstream %d: %w", i, err))
+			continue
+		}
+		if upstreams[upstream.Name] {
+			errs = append(errs, fmt.Errorf("upstream %d: duplicate name %q", i, upstream.Name))
+			continue
+		}
+		upstreams[upstream.Name] = true
+	}
+
+	services := make(map[string]bool)
+	for i, service := range c.Services {
+		if err := service.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("service %d: %w", i, err))
+			continue
+		}
+		if services[service.Name] {
+			errs = append(errs, fmt.Errorf("service %d: duplicate name %q", i, service.Name))
+			continue
+		}
+		if service.Provider == ProviderFile && c.Discovery.File.Path == "" {
+			errs = append(errs, fmt.Errorf("service %d: discovery.file.path is required by the file provider", i))
+			continue
+		}
+		services[service.Name] = true
+	}
+
+	names := make(map[string]int)
+	seen := make(map[string]int)
+	for i, route := range c.Routes {
+		if err := route.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("route %d: %w", i, err))
+			continue
+		}
+		if route.Upstream != "" && !upstreams[route.Upstream] {
+			errs = append(errs, fmt.Errorf("route %d: unknown upstream %q", i, route.Upstream))
+			continue
+		}
+		if route.Service != "" && !services[route.Service] {
+			errs = append(errs, fmt.Errorf("route %d: unknown service %q", i, route.Service))
+			continue
+		}
+		if err := c.validateRouteProtocol(route); err != nil {
+			errs = append(errs, fmt.Errorf("route %d: %w", i, err))
+			continue
+		}
+		if j, ok := names[route.Name]; ok {
+			errs = append(errs, fmt.Errorf("route %d: name %q duplicates route %d", i, route.Name, j))
+			continue
+		}
+		names[route.Name] = i
+		key := route.Method + " " + route.Path
+		if j, ok := seen[key]; ok {
+			errs = append(errs, fmt.Errorf("route %d: %s duplicates route %d", i, key, j))
+			continue
+		}
+		seen[key] = i
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateRouteProtocol checks that a route's upstream protocol fits the
+// scheme of its target: h2 needs TLS and h2c must not use it
+func (c *Config) validateRouteProtocol(route Route) error {
+	if route.Protocol != ProtocolH2 && route.Protocol != ProtocolH2C {
+		return nil
+	}
+
+	var scheme string
+	if i := c.FindService(route.Service); route.Service != "" && i >= 0 {
+		scheme = c.Services[i].Scheme
+	} else if target, err := url.Parse(c.TargetURL(route)); err == nil {
+		scheme = target.Scheme
+	}
+	if route.Protocol == ProtocolH2 && scheme != "https" {
+		return errors.New("protocol h2 requires an https target, use h2c for cleartext HTTP/2")
+	}
+	if route.Protocol == ProtocolH2C && scheme != "http" {
+		return errors.New("protocol h2c requires an http target")
+	}
+	return nil
+}
+
+func (s ServerConfig) validate() []error {
+	var errs []error
+	if s.Address != "" && net.ParseIP(strings.Trim(s.Address, "[]")) == nil {
+		errs = append(errs, fmt.Errorf("server: address %q must be an IP address", s.Address))
+	}
+	for _, timeout := range []struct {
+		name  string
+		value Duration
+	}{
+		{"readTimeout", s.ReadTimeout},
+		{"readHeaderTimeout", s.ReadHeaderTimeout},
+		{"writeTimeout", s.WriteTimeout},
+		{"idleTimeout", s.IdleTimeout},
+		{"shutdown.drainDelay", s.Shutdown.DrainDelay},
+		{"shutdown.timeout", s.Shutdown.Timeout},
+	} {
+		if timeout.value < 0 {
+			errs = append(errs, fmt.Errorf("server: %s must not be negative", timeout.name))
+		}
+	}
+	if s.MaxHeaderBytes < 0 {
+		errs = append(errs, errors.New("server: maxHeaderBytes must not be negative"))
+	}
+	if s.MaxConnections < 0 {
+		errs = append(errs, errors.New("server: maxConnections must not be negative"))
+	}
+
+	proxied := s.ProxyProtocol.Enabled
+	seen := make(map[Listener]int)
+	for i, listener := range s.Listeners {
+		// Local Unix socket peers are always trusted
+		proxied = proxied || listener.ProxyProtocol && listener.Network == NetworkTCP
+		if err := listener.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("server.listeners %d: %w", i, err))
+			continue
+		}
+		if j, ok := seen[listener]; ok {
+			errs = append(errs, fmt.Errorf("server.listeners %d: duplicates listener %d", i, j))
+			continue
+		}
+		seen[listener] = i
+	}
+	if proxied && len(s.ProxyProtocol.TrustedCIDRs) == 0 {
+		errs = append(errs, errors.New("server.proxyProtocol: trustedCidrs are required"))
+	}
+	for i, cidr := range s.ProxyProtocol.TrustedCIDRs {
+		if _, err := ParseCIDR(cidr); err != nil {
+			errs = append(errs, fmt.Errorf("server.proxyProtocol: trusted CIDR %d: %w", i, err))
+		}
+	}
+	for i, cidr := range s.TrustedProxies {
+		if _, err := ParseCIDR(cidr); err != nil {
+			errs = append(errs, fmt.Errorf("server.trustedProxies %d: %w", i, err))
+		}
+	}
+	return errs
+}
+
+// ParseCIDRs parses a list of IP ranges with ParseCIDR
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		ipNet, err := ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+// ParseCIDR parses an IP range such as "10.0.0.0/8". A single IP address is
+// accepted as a range containing only that address.
+func ParseCIDR(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", cidr)
+		}
+		bits := 8 * len(ip)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", cidr)
+	}
+	return ipNet, nil
+}
+
+// Validate checks a single listener for errors
+func (l Listener) Validate() error {
+	if l.Address == "" {
+		return errors.New("address is required")
+	}
+	switch l.Network {
+	case NetworkTCP:
+		if _, port, err := net.SplitHostPort(l.Address); err != nil || port == "" {
+			return fmt.Errorf("address %q must be host:port", l.Address)
+		}
+	case NetworkUnix:
+	default:
+		return fmt.Errorf("unsupported network %q, expected tcp or unix", l.Network)
+	}
+	return nil
+}
+
+func (t TLSConfig) validate(plainPort int) []error {
+	if !t.Enabled() {
+		if t.HTTP3.Enabled() {
+			return []error{errors.New("server.tls.http3: requires the TLS listener")}
+		}
+		return nil
+	}
+
+	var errs []error
+	if t.HTTP3.Port < 0 || t.HTTP3.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.tls.http3: invalid port %d", t.HTTP3.Port))
+	}
+	if t.HTTP3.MaxAge < 0 {
+		errs = append(errs, errors.New("server.tls.http3: maxAge must not be negative"))
+	}
+	if t.Port < 0 || t.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.tls: invalid port %d", t.Port))
+	}
+	if t.Port == plainPort {
+		errs = append(errs, fmt.Errorf("server.tls: port %d is already used by the server", t.Port))
+	}
+	if len(t.Certificates) == 0 && !t.ACME.Enabled() {
+		errs = append(errs, errors.New("server.tls: at least one certificate or ACME host is required"))
+	}
+	for i, host := range t.ACME.Hosts {
+		if host == "" || strings.ContainsAny(host, ":/ ") {
+			errs = append(errs, fmt.Errorf("server.tls.acme: invalid host %d %q", i, host))
+		}
+	}
+	if t.ACME.RenewBefore < 0 {
+		errs = append(errs, errors.New("server.tls.acme: renewBefore must not be negative"))
+	}
+	for i, cert := range t.Certificates {
+		if cert.CertFile == "" || cert.KeyFile == "" {
+			errs = append(errs, fmt.Errorf("server.tls: certificate %d needs a certFile and a keyFile", i))
+		}
+	}
+	if _, err := t.TLSVersion(); err != nil {
+		errs = append(errs, fmt.Errorf("server.tls: %w", err))
+	}
+	if _, err := t.CipherSuiteIDs(); err != nil {
+		errs = append(errs, fmt.Errorf("server.tls: %w", err))
+	}
+	return errs
+}
+
+// Validate checks a single upstream for errors
+func (u Upstream) Validate() error {
+	if u.Name == "" {
+		return errors.New("name is required")
+	}
+	return validateTargetURL("url", u.URL)
+}
+
+// Validate checks a single service for errors
+func (s Service) Validate() error {
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	if s.Scheme != "" && s.Scheme != "http" && s.Scheme != "https" {
+		return fmt.Errorf("scheme %q must be http or https", s.Scheme)
+	}
+
+	if s.Provider != ProviderConsul && (len(s.Tags) > 0 || s.Datacenter != "") {
+		return errors.New("tags and datacenter are only supported by the consul provider")
+	}
+
+	switch s.Provider {
+	case ProviderFile, ProviderConsul:
+		return nil
+	case ProviderDNS:
+		if (s.Host == "") == (s.SRV == "") {
+			return errors.New("dns provider needs exactly one of host or srv")
+		}
+		if s.Host != "" && (s.Port <= 0 || s.Port > 65535) {
+			return fmt.Errorf("dns provider needs a valid port for host %q", s.Host)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown provider %q", s.Provider)
+	}
+}
+
+// Validate checks a single route for errors. References to upstreams and
+// services are checked by Config.Validate.
+func (r Route) Validate() error {
+	if r.Path == "" || !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path %q must start with /", r.Path)
+	}
+	if !validMethods[strings.ToUpper(r.Method)] {
+		return fmt.Errorf("unsupported method %q", r.Method)
+	}
+	if strings.ContainsAny(r.Name, "/ ") {
+		return fmt.Errorf("name %q must not contain slashes or spaces", r.Name)
+	}
+	switch r.Protocol {
+	case "", ProtocolHTTP1, ProtocolH2, ProtocolH2C:
+	default:
+		return fmt.Errorf("unsupported protocol %q, expected http1, h2 or h2c", r.Protocol)
+	}
+	if r.Upstream != "" && r.Service != "" {
+		return errors.New("upstream and service are mutually exclusive")
+	}
+	if r.Upstream != "" || r.Service != "" {
+		if strings.Contains(r.TargetURL, "://") {
+			return fmt.Errorf("targetUrl %q must be a path when an upstream or service is set", r.TargetURL)
+		}
+		return nil
+	}
+
+	return validateTargetURL("targetUrl", r.TargetURL)
+}
+
+func validateTargetURL(field, rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", field, err)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("%s %q must use http or https", field, rawURL)
+	}
+	if target.Host == "" {
+		return fmt.Errorf("%s %q has no host", field, rawURL)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/gateway"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+)
+
+const defaultAddress = "127.0.0.1"
+
+var (
+	errNotFound      = errors.New("not found")
+	errAlreadyExists = errors.New("already exists")
+)
+
+type contextKey int
+
+const actorKey contextKey = iota
+
+// Server exposes the admin API on its own listener
+type Server struct {
+	config     config.AdminConfig
+	store      *config.Store
+	router     *mux.Router
+	server     *http.Server
+	logService logging.Logger
+}
+
+// NewServer creates the admin API server for the given configuration store
+func NewServer(cfg config.AdminConfig, store *config.Store, logger logging.Logger) *Server {
+	s := &Server{
+		config:     cfg,
+		store:      store,
+		router:     mux.NewRouter(),
+		logService: logger,
+	}
+	s.setupRoutes()
+	return s
+}
+
+func (s *Server) setupRoutes() {
+	api := s.router.PathPrefix("/admin").Subrouter()
+	api.Use(s.authMiddleware)
+
+	api.HandleFunc("/routes", s.listRoutes).Methods("GET")
+	api.HandleFunc("/routes", s.createRoute).Methods("POST")
+	api.HandleFunc("/routes/{name}", s.getRoute).Methods("GET")
+	api.HandleFunc("/routes/{name}", s.updateRoute).Methods("PUT")
+	api.HandleFunc("/routes/{name}", s.deleteRoute).Methods("DELETE")
+
+	api.HandleFunc("/upstreams", s.listUpstreams).Methods("GET")
+	api.HandleFunc("/upstreams", s.createUpstream).Methods("POST")
+	api.HandleFunc("/upstreams/{name}", s.getUpstream).Methods("GET")
+	api.HandleFunc("/upstreams/{name}", s.updateUpstream).Methods("PUT")
+	api.HandleFunc("/upstreams/{name}", s.deleteUpstream).Methods("DELETE")
+
+	api.HandleFunc("/config", s.getConfig).Methods("GET")
+	api.HandleFunc("/config/history", s.listHistory).Methods("GET")
+	api.HandleFunc("/config/history/{version:[0-9]+}", s.getRevision).Methods("GET")
+	api.HandleFunc("/config/history/{version:[0-9]+}/rollback", s.rollback).Methods("POST")
+}
+
+// Handler returns the HTTP handler serving the admin API
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// Mount serves handler, without authentication, for requests outside the
+// admin API, e.g. the gateway's health endpoints
+func (s *Server) Mount(handler http.Handler) {
+	s.router.NotFoundHandler = handler
+}
+
+// Address returns the address the admin server listens on. Only local
+// clients can reach it unless a bind address is configured.
+func (s *Server) Address() string {
+	address := s.config.Address
+	if address == "" {
+		address = defaultAddress
+	}
+	return net.JoinHostPort(address, strconv.Itoa(s.config.Port))
+}
+
+// Run starts the admin server
+func (s *Server) Run() error {
+	s.server = s.newHTTPServer()
+	return s.server.ListenAndServe()
+}
+
+// newHTTPServer creates the admin server with the timeouts and header limit
+// of the gateway's listeners
+func (s *Server) newHTTPServer() *http.Server {
+	cfg, _ := s.store.Current()
+	server := gateway.NewHTTPServer(cfg.Server, s.router)
+	server.Addr = s.Address()
+	return server
+}
+
+// Shutdown gracefully shuts down the admin server
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
+
+// authMiddleware accepts requests carrying one of the bearer tokens of the
+// active configuration and records the token name as the actor of the
+// request. Tokens are read on every request so that rotating them through a
+// reload or the admin API takes effect immediately.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			cfg, _ := s.store.Current()
+			for _, t := range cfg.Admin.Tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+					ctx := context.WithValue(r.Context(), actorKey, t.Name)
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+			}
+		}
+		writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+	})
+}
+
+// actor returns the name of the token that authenticated the request
+func actor(r *http.Request) string {
+	name, _ := r.Context().Value(actorKey).(string)
+	return name
+}
+
+// update applies a change to the store, honouring an If-Match version, and
+// writes the resulting version or the appropriate error
+func (s *Server) update(w http.ResponseWriter, r *http.Request, status int, mutate func(*config.Config) (any, error)) {
+	expected, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var result any
+	origin := config.Origin{Source: config.SourceAdmin, Actor: actor(r)}
+	_, version, err := s.store.Update(expected, origin, func(cfg *config.Config) error {
+		var err error
+		result, err = mutate(cfg)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	s.logService.Infof("Admin %s %s %s applied config version %d", actor(r), r.Method, r.URL.Path, version)
+	writeJSON(w, status, version, result)
+}
+
+// ifMatchVersion reads the expected config version from the If-Match header.
+// Zero means the request is unconditional.
+func ifMatchVersion(r *http.Request) (uint64, error) {
+	value := r.Header.Get("If-Match")
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+	value = strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+	version, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || version == 0 {
+		return 0, fmt.Errorf("invalid If-Match version %q", r.Header.Get("If-Match"))
+	}
+	return version, nil
+}
+
+func decodeBody(r *http.Request, v any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+// writeJSON writes v as the response body with the config version as ETag
+func writeJSON(w http.ResponseWriter, status int, version uint64, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if version != 0 {
+		w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(version, 10)))
+	}
+	w.WriteHeader(status)
+	if v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, 0, map[string]string{"error": err.Error()})
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	var validationErr *config.ValidationError
+	switch {
+	case errors.Is(err, errNotFound), errors.Is(err, config.ErrVersionNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, errAlreadyExists):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, config.ErrVersionConflict):
+		writeError(w, http.StatusPreconditionFailed, err)
+	case errors.As(err, &validationErr):
+		writeError(w, http.StatusUnprocessableEntity, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
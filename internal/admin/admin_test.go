@@ -0,0 +1,252 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+)
+
+const testToken = "secret"
+
+func newTestServer(t *testing.T) (*Server, *config.Store, string) {
+	cfg := &config.Config{
+		Server: config.ServerConfig{Port: 8080},
+		Admin: config.AdminConfig{
+			Port:   9000,
+			Tokens: []config.AdminToken{{Name: "ops", Token: testToken}},
+		},
+		Routes: []config.Route{
+			{Name: "users", Path: "/api/users", TargetURL: "http://user-service:8081/users", Method: "GET"},
+		},
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	store := config.NewStore(path, "", cfg)
+	return NewServer(cfg.Admin, store, logging.Discard()), store, path
+}
+
+func doRequest(s *Server, method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAdmin_ListensOnLoopbackByDefault(t *testing.T) {
+	s, store, _ := newTestServer(t)
+	assert.Equal(t, "127.0.0.1:9000", s.Address())
+
+	s = NewServer(config.AdminConfig{Address: "0.0.0.0", Port: 9000}, store, logging.Discard())
+	assert.Equal(t, "0.0.0.0:9000", s.Address())
+	s = NewServer(config.AdminConfig{Address: "::1", Port: 9000}, store, logging.Discard())
+	assert.Equal(t, "[::1]:9000", s.Address())
+}
+
+func TestAdmin_UsesServerTimeouts(t *testing.T) {
+	s, store, _ := newTestServer(t)
+	cfg, version := store.Current()
+	_, _, err := store.Update(version, config.Origin{Source: config.SourceFile}, func(c *config.Config) error {
+		c.Server.ReadHeaderTimeout = config.Duration(2 * time.Second)
+		return nil
+	})
+	require.NoError(t, err)
+
+	server := s.newHTTPServer()
+	assert.Equal(t, "127.0.0.1:9000", server.Addr)
+	assert.Equal(t, 2*time.Second, server.ReadHeaderTimeout)
+	assert.NotZero(t, server.ReadTimeout)
+	assert.NotZero(t, server.WriteTimeout)
+	assert.NotZero(t, server.IdleTimeout)
+	assert.Zero(t, cfg.Server.ReadHeaderTimeout, "the previous configuration is untouched")
+}
+
+func TestAdmin_RequiresToken(t *testing.T) {
+	s, _, _ := newTestServer(t)
+
+	req := httptest.NewRequest("GET", "/admin/routes", nil)
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = doRequest(s, "GET", "/admin/routes", "", map[string]string{"Authorization": "Bearer wrong"})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestAdmin_RotatedTokensTakeEffect(t *testing.T) {
+	s, store, _ := newTestServer(t)
+	_, _, err := store.Update(0, config.Origin{Source: config.SourceFile}, func(c *config.Config) error {
+		c.Admin.Tokens = []config.AdminToken{{Name: "deploy", Token: "rotated"}}
+		return nil
+	})
+	require.NoError(t, err)
+
+	rr := doRequest(s, "GET", "/admin/routes", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "the revoked token is rejected")
+
+	rr = doRequest(s, "GET", "/admin/routes", "", map[string]string{"Authorization": "Bearer rotated"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestAdmin_MountServesWithoutToken(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	s.Mount(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/livez", nil))
+	assert.Equal(t, http.StatusTeapot, rr.Code)
+
+	// The admin API still requires a token
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/admin/routes", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestAdmin_ListAndGetRoutes(t *testing.T) {
+	s, _, _ := newTestServer(t)
+
+	rr := doRequest(s, "GET", "/admin/routes", "", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+
+	var routes []config.Route
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &routes))
+	require.Len(t, routes, 1)
+	assert.Equal(t, "users", routes[0].Name)
+
+	rr = doRequest(s, "GET", "/admin/routes/users", "", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = doRequest(s, "GET", "/admin/routes/missing", "", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestAdmin_CreateRoutePersists(t *testing.T) {
+	s, store, path := newTestServer(t)
+
+	body := `{"path": "/api/orders", "targetUrl": "http://order-service:8083/orders", "method": "post"}`
+	rr := doRequest(s, "POST", "/admin/routes", body, map[string]string{"If-Match": `"1"`})
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+
+	var route config.Route
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &route))
+	assert.Equal(t, "post-api-orders", route.Name)
+	assert.Equal(t, "POST", route.Method)
+
+	cfg, _ := store.Current()
+	assert.Len(t, cfg.Routes, 2)
+
+	saved, err := config.LoadConfig(path)
+	require.NoError(t, err)
+	assert.Len(t, saved.Routes, 2)
+
+	rr = doRequest(s, "POST", "/admin/routes", body, nil)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestAdmin_StaleVersionRejected(t *testing.T) {
+	s, _, _ := newTestServer(t)
+
+	body := `{"path": "/api/users", "targetUrl": "http://user-service:8081/v2/users", "method": "GET"}`
+	rr := doRequest(s, "PUT", "/admin/routes/users", body, map[string]string{"If-Match": `"1"`})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = doRequest(s, "PUT", "/admin/routes/users", body, map[string]string{"If-Match": `"1"`})
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+	rr = doRequest(s, "PUT", "/admin/routes/users", body, map[string]string{"If-Match": "abc"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestAdmin_ValidationMatchesFileLoading(t *testing.T) {
+	s, store, _ := newTestServer(t)
+
+	rr := doRequest(s, "POST", "/admin/routes", `{"path": "no-slash", "targetUrl": "ftp://x"}`, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = doRequest(s, "POST", "/admin/routes", `{"path": "/x", "targetUrl": "http://x", "bogus": true}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	_, version := store.Current()
+	assert.Equal(t, uint64(1), version)
+}
+
+func TestAdmin_UpstreamLifecycle(t *testing.T) {
+	s, store, _ := newTestServer(t)
+
+	rr := doRequest(s, "POST", "/admin/upstreams", `{"name": "orders", "url": "http://order-service:8083"}`, nil)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	rr = doRequest(s, "POST", "/admin/routes", `{"name": "orders", "path": "/api/orders", "upstream": "orders", "targetUrl": "/orders"}`, nil)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	cfg, _ := store.Current()
+	assert.Equal(t, "http://order-service:8083/orders", cfg.TargetURL(cfg.Routes[1]))
+
+	// Upstreams still referenced by routes cannot be removed
+	rr = doRequest(s, "DELETE", "/admin/upstreams/orders", "", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = doRequest(s, "DELETE", "/admin/routes/orders", "", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = doRequest(s, "DELETE", "/admin/upstreams/orders", "", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	cfg, version := store.Current()
+	assert.Empty(t, cfg.Upstreams)
+	assert.Equal(t, uint64(5), version)
+}
+
+func TestAdmin_HistoryAndRollback(t *testing.T) {
+	s, store, path := newTestServer(t)
+
+	body := `{"path": "/api/users", "targetUrl": "http://user-service:8081/v2/users", "method": "GET"}`
+	rr := doRequest(s, "PUT", "/admin/routes/users", body, nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = doRequest(s, "GET", "/admin/config/history", "", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var history []config.Revision
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &history))
+	require.Len(t, history, 2)
+	assert.Equal(t, uint64(2), history[0].Version)
+	assert.Equal(t, "ops", history[0].Actor)
+	assert.Equal(t, config.SourceAdmin, history[0].Source)
+	assert.Contains(t, history[0].Diff, "+  targetUrl: http://user-service:8081/v2/users")
+
+	rr = doRequest(s, "GET", "/admin/config/history/1", "", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"targetUrl":"http://user-service:8081/users"`)
+	assert.NotContains(t, rr.Body.String(), testToken)
+
+	rr = doRequest(s, "POST", "/admin/config/history/1/rollback", "", map[string]string{"If-Match": `"1"`})
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+	rr = doRequest(s, "POST", "/admin/config/history/1/rollback", "", map[string]string{"If-Match": `"2"`})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
+
+	cfg, _ := store.Current()
+	assert.Equal(t, "http://user-service:8081/users", cfg.Routes[0].TargetURL)
+	saved, err := config.LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "http://user-service:8081/users", saved.Routes[0].TargetURL)
+
+	rr = doRequest(s, "POST", "/admin/config/history/99/rollback", "", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
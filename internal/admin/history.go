@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/leo-andrei/api-gateway/config"
+)
+
+// revisionDetail is a history entry together with the configuration it applied
+type revisionDetail struct {
+	config.Revision
+	Config *config.Config `json:"config"`
+}
+
+func (s *Server) getConfig(w http.ResponseWriter, r *http.Request) {
+	cfg, version := s.store.Current()
+	writeJSON(w, http.StatusOK, version, cfg.Redacted())
+}
+
+func (s *Server) listHistory(w http.ResponseWriter, r *http.Request) {
+	_, version := s.store.Current()
+	writeJSON(w, http.StatusOK, version, s.store.History())
+}
+
+func (s *Server) getRevision(w http.ResponseWriter, r *http.Request) {
+	_, current := s.store.Current()
+	version, err := strconv.ParseUint(mux.Vars(r)["version"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid version"))
+		return
+	}
+
+	revision, err := s.store.Revision(version)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, current, revisionDetail{Revision: revision, Config: revision.Config.Redacted()})
+}
+
+// rollback re-applies a previous configuration as a new version
+func (s *Server) rollback(w http.ResponseWriter, r *http.Request) {
+	expected, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	target, err := strconv.ParseUint(mux.Vars(r)["version"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid version"))
+		return
+	}
+
+	origin := config.Origin{Source: config.SourceRollback, Actor: actor(r)}
+	_, version, err := s.store.Rollback(expected, target, origin)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	s.logService.Infof("Admin %s rolled back config to version %d, applied as version %d", actor(r), target, version)
+	revision, _ := s.store.Revision(version)
+	writeJSON(w, http.StatusOK, version, revision)
+}
@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/leo-andrei/api-gateway/config"
+)
+
+func (s *Server) listRoutes(w http.ResponseWriter, r *http.Request) {
+	cfg, version := s.store.Current()
+	routes := cfg.Routes
+	if routes == nil {
+		routes = []config.Route{}
+	}
+	writeJSON(w, http.StatusOK, version, routes)
+}
+
+func (s *Server) getRoute(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	cfg, version := s.store.Current()
+	i := cfg.FindRoute(name)
+	if i < 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("route %q: %w", name, errNotFound))
+		return
+	}
+	writeJSON(w, http.StatusOK, version, cfg.Routes[i])
+}
+
+func (s *Server) createRoute(w http.ResponseWriter, r *http.Request) {
+	var route config.Route
+	if err := decodeBody(r, &route); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	route.ApplyDefaults()
+	s.update(w, r, http.StatusCreated, func(cfg *config.Config) (any, error) {
+		if cfg.FindRoute(route.Name) >= 0 {
+			return nil, fmt.Errorf("route %q: %w", route.Name, errAlreadyExists)
+		}
+		cfg.Routes = append(cfg.Routes, route)
+		return route, nil
+	})
+}
+
+func (s *Server) updateRoute(w http.ResponseWriter, r *http.Request) {
+	var route config.Route
+	if err := decodeBody(r, &route); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	name := mux.Vars(r)["name"]
+
+	s.update(w, r, http.StatusOK, func(cfg *config.Config) (any, error) {
+		i := cfg.FindRoute(name)
+		if i < 0 {
+			return nil, fmt.Errorf("route %q: %w", name, errNotFound)
+		}
+		route.Name = name
+		route.ApplyDefaults()
+		cfg.Routes[i] = route
+		return route, nil
+	})
+}
+
+func (s *Server) deleteRoute(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	s.update(w, r, http.StatusNoContent, func(cfg *config.Config) (any, error) {
+		i := cfg.FindRoute(name)
+		if i < 0 {
+			return nil, fmt.Errorf("route %q: %w", name, errNotFound)
+		}
+		cfg.Routes = append(cfg.Routes[:i], cfg.Routes[i+1:]...)
+		return nil, nil
+	})
+}
@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/leo-andrei/api-gateway/config"
+)
+
+func (s *Server) listUpstreams(w http.ResponseWriter, r *http.Request) {
+	cfg, version := s.store.Current()
+	upstreams := cfg.Upstreams
+	if upstreams == nil {
+		upstreams = []config.Upstream{}
+	}
+	writeJSON(w, http.StatusOK, version, upstreams)
+}
+
+func (s *Server) getUpstream(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	cfg, version := s.store.Current()
+	i := cfg.FindUpstream(name)
+	if i < 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("upstream %q: %w", name, errNotFound))
+		return
+	}
+	writeJSON(w, http.StatusOK, version, cfg.Upstreams[i])
+}
+
+func (s *Server) createUpstream(w http.ResponseWriter, r *http.Request) {
+	var upstream config.Upstream
+	if err := decodeBody(r, &upstream); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.update(w, r, http.StatusCreated, func(cfg *config.Config) (any, error) {
+		if cfg.FindUpstream(upstream.Name) >= 0 {
+			return nil, fmt.Errorf("upstream %q: %w", upstream.Name, errAlreadyExists)
+		}
+		cfg.Upstreams = append(cfg.Upstreams, upstream)
+		return upstream, nil
+	})
+}
+
+func (s *Server) updateUpstream(w http.ResponseWriter, r *http.Request) {
+	var upstream config.Upstream
+	if err := decodeBody(r, &upstream); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	name := mux.Vars(r)["name"]
+
+	s.update(w, r, http.StatusOK, func(cfg *config.Config) (any, error) {
+		i := cfg.FindUpstream(name)
+		if i < 0 {
+			return nil, fmt.Errorf("upstream %q: %w", name, errNotFound)
+		}
+		upstream.Name = name
+		cfg.Upstreams[i] = upstream
+		return upstream, nil
+	})
+}
+
+// deleteUpstream removes an upstream. Deleting an upstream that routes still
+// reference fails validation.
+func (s *Server) deleteUpstream(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	s.update(w, r, http.StatusNoContent, func(cfg *config.Config) (any, error) {
+		i := cfg.FindUpstream(name)
+		if i < 0 {
+			return nil, fmt.Errorf("upstream %q: %w", name, errNotFound)
+		}
+		cfg.Upstreams = append(cfg.Upstreams[:i], cfg.Upstreams[i+1:]...)
+		return nil, nil
+	})
+}
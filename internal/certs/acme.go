@@ -0,0 +1,193 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+	"github.com/leo-andrei/api-gateway/internal/metrics"
+)
+
+const defaultACMECacheDir = "certs/acme"
+
+// ACME obtains and renews certificates for the configured hosts, answering
+// TLS-ALPN-01 challenges on the TLS listener and HTTP-01 challenges through
+// HTTPHandler
+type ACME struct {
+	manager        *autocert.Manager
+	hosts          []string
+	logService     logging.Logger
+	metricsService metrics.Metrics
+}
+
+// NewACME creates an ACME certificate manager. Certificates and the account
+// key are kept in cfg.CacheDir so they survive restarts.
+func NewACME(cfg config.ACMEConfig, logger logging.Logger, metrics metrics.Metrics) (*ACME, error) {
+	if !cfg.Enabled() {
+		return nil, errors.New("no ACME hosts configured")
+	}
+
+	client := &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	if cfg.CAFile != "" {
+		pool, err := loadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	cacheDir := cfg.CacheDir
+	if cacheDir == "" {
+		cacheDir = defaultACMECacheDir
+	}
+
+	hosts := make([]string, len(cfg.Hosts))
+	for i, host := range cfg.Hosts {
+		hosts[i] = normalizeHost(host)
+	}
+	a := &ACME{
+		hosts:          hosts,
+		logService:     logger,
+		metricsService: metrics,
+	}
+	a.manager = &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       &observingCache{Cache: autocert.DirCache(cacheDir), acme: a},
+		HostPolicy:  autocert.HostWhitelist(cfg.Hosts...),
+		RenewBefore: cfg.RenewBefore.Std(),
+		Client:      client,
+		Email:       cfg.Email,
+	}
+	return a, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("loading ACME CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("loading ACME CA file %s: no certificates found", file)
+	}
+	return pool, nil
+}
+
+// Handles reports whether the connection should be served an ACME
+// certificate, either because its SNI name is an ACME host or because it is a
+// TLS-ALPN-01 challenge
+func (a *ACME) Handles(hello *tls.ClientHelloInfo) bool {
+	if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+		return true
+	}
+	return slices.Contains(a.hosts, normalizeHost(hello.ServerName))
+}
+
+func normalizeHost(serverName string) string {
+	return strings.ToLower(strings.TrimSuffix(serverName, "."))
+}
+
+// hostLabel returns the metric label of an SNI name. Names outside the host
+// policy are chosen by clients, so they share metrics.OverflowValue.
+func (a *ACME) hostLabel(serverName string) string {
+	host := normalizeHost(serverName)
+	if slices.Contains(a.hosts, host) {
+		return host
+	}
+	return metrics.OverflowValue
+}
+
+// GetCertificate returns the certificate for the client's SNI name, obtaining
+// it from the CA on first use
+func (a *ACME) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := a.manager.GetCertificate(hello)
+	if err != nil && !slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+		a.logService.Errorf("TLS: error getting ACME certificate for %s: %v", hello.ServerName, err)
+		a.metricsService.IncrementCertificateErrors(a.hostLabel(hello.ServerName))
+	}
+	return cert, err
+}
+
+// HTTPHandler answers HTTP-01 challenges and passes every other request to
+// fallback
+func (a *ACME) HTTPHandler(fallback http.Handler) http.Handler {
+	return a.manager.HTTPHandler(fallback)
+}
+
+// observe records a certificate the manager loaded from or stored in the cache
+func (a *ACME) observe(host string, data []byte, issued bool) {
+	leaf := parseLeaf(data)
+	if leaf == nil {
+		return
+	}
+	a.metricsService.SetCertificateExpiry(host, float64(leaf.NotAfter.Unix()))
+	if issued {
+		a.metricsService.IncrementCertificateIssued(host)
+		a.logService.Infof("TLS: obtained ACME certificate for %s, valid until %s", host, leaf.NotAfter.Format("2006-01-02 15:04:05 MST"))
+	}
+}
+
+// parseLeaf returns the first certificate in a cache entry, which autocert
+// stores as the private key followed by the chain. It returns nil for entries
+// that hold no certificate, such as the account key or challenge tokens.
+func parseLeaf(data []byte) *x509.Certificate {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil
+		}
+		if block.Type == "CERTIFICATE" {
+			leaf, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil
+			}
+			return leaf
+		}
+	}
+}
+
+// observingCache reports the certificates passing through an autocert cache,
+// which is where issuance and renewal become visible
+type observingCache struct {
+	autocert.Cache
+	acme *ACME
+}
+
+func (c *observingCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.Cache.Get(ctx, key)
+	if err == nil {
+		c.acme.observe(cacheKeyHost(key), data, false)
+	}
+	return data, err
+}
+
+func (c *observingCache) Put(ctx context.Context, key string, data []byte) error {
+	if err := c.Cache.Put(ctx, key, data); err != nil {
+		return err
+	}
+	c.acme.observe(cacheKeyHost(key), data, true)
+	return nil
+}
+
+// cacheKeyHost strips the key type suffix autocert adds for RSA certificates
+func cacheKeyHost(key string) string {
+	return strings.TrimSuffix(key, "+rsa")
+}
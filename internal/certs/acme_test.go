@@ -0,0 +1,201 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+	"github.com/leo-andrei/api-gateway/internal/metrics"
+)
+
+// recordingMetrics records the certificate metrics reported by the manager
+type recordingMetrics struct {
+	metrics.Metrics
+	issued map[string]int
+	errors map[string]int
+	expiry map[string]float64
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		issued: map[string]int{},
+		errors: map[string]int{},
+		expiry: map[string]float64{},
+	}
+}
+
+func (m *recordingMetrics) IncrementCertificateIssued(host string) { m.issued[host]++ }
+func (m *recordingMetrics) IncrementCertificateErrors(host string) { m.errors[host]++ }
+func (m *recordingMetrics) SetCertificateExpiry(host string, timestamp float64) {
+	m.expiry[host] = timestamp
+}
+
+func TestACME_Handles(t *testing.T) {
+	manager, err := NewACME(config.ACMEConfig{Hosts: []string{"acme.example.com"}, CacheDir: t.TempDir()}, logging.Discard(), newRecordingMetrics())
+	require.NoError(t, err)
+
+	assert.True(t, manager.Handles(&tls.ClientHelloInfo{ServerName: "acme.example.com"}))
+	assert.True(t, manager.Handles(&tls.ClientHelloInfo{ServerName: "ACME.example.com."}))
+	assert.True(t, manager.Handles(&tls.ClientHelloInfo{ServerName: "other.example.com", SupportedProtos: []string{acme.ALPNProto}}))
+	assert.False(t, manager.Handles(&tls.ClientHelloInfo{ServerName: "other.example.com"}))
+	assert.False(t, manager.Handles(&tls.ClientHelloInfo{}))
+}
+
+func TestNewACME_RequiresHosts(t *testing.T) {
+	_, err := NewACME(config.ACMEConfig{}, logging.Discard(), newRecordingMetrics())
+	assert.Error(t, err)
+}
+
+func TestACME_CacheReportsCertificates(t *testing.T) {
+	dir := t.TempDir()
+	recorder := newRecordingMetrics()
+	manager, err := NewACME(config.ACMEConfig{Hosts: []string{"a.example.com"}, CacheDir: dir}, logging.Discard(), recorder)
+	require.NoError(t, err)
+
+	// autocert stores the key followed by the chain in a single entry
+	file := writeCertificate(t, dir, "a.example.com", 1)
+	certPEM, err := os.ReadFile(file.CertFile)
+	require.NoError(t, err)
+	keyPEM, err := os.ReadFile(file.KeyFile)
+	require.NoError(t, err)
+
+	cache := manager.manager.Cache
+	ctx := context.Background()
+	require.NoError(t, cache.Put(ctx, "a.example.com", append(keyPEM, certPEM...)))
+	require.NoError(t, cache.Put(ctx, "acme_account+key", keyPEM))
+
+	assert.Equal(t, map[string]int{"a.example.com": 1}, recorder.issued)
+	assert.Contains(t, recorder.expiry, "a.example.com")
+
+	// Loading a cached certificate on restart updates the expiry only
+	recorder.expiry = map[string]float64{}
+	_, err = cache.Get(ctx, "a.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 1, recorder.issued["a.example.com"])
+	assert.Contains(t, recorder.expiry, "a.example.com")
+}
+
+func TestNewTLSConfig_CombinesStoreAndACME(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TLSConfig{
+		Certificates: []config.Certificate{writeCertificate(t, dir, "static.example.com", 1)},
+		ACME:         config.ACMEConfig{Hosts: []string{"acme.example.com"}, CacheDir: t.TempDir()},
+	}
+	store, err := NewStore(cfg, logging.Discard())
+	require.NoError(t, err)
+	defer store.Close()
+	recorder := newRecordingMetrics()
+	manager, err := NewACME(cfg.ACME, logging.Discard(), recorder)
+	require.NoError(t, err)
+
+	tlsConfig, err := NewTLSConfig(cfg, store, manager)
+	require.NoError(t, err)
+	assert.Contains(t, tlsConfig.NextProtos, acme.ALPNProto)
+
+	cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "static.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), serialOf(t, cert))
+
+	assert.Empty(t, recorder.errors)
+}
+
+func TestACME_RejectsUnlistedHosts(t *testing.T) {
+	recorder := newRecordingMetrics()
+	manager, err := NewACME(config.ACMEConfig{Hosts: []string{"acme.example.com"}, CacheDir: t.TempDir()}, logging.Discard(), recorder)
+	require.NoError(t, err)
+
+	// The host policy refuses the name before anything is sent to the CA
+	_, err = manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "unlisted.example.com"})
+	assert.Error(t, err)
+
+	// Client-chosen names share one label so they cannot add time series
+	_, err = manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "random-1234.example.com"})
+	assert.Error(t, err)
+	assert.Equal(t, map[string]int{metrics.OverflowValue: 2}, recorder.errors)
+}
+
+func TestACME_ErrorsLabelledByHost(t *testing.T) {
+	recorder := newRecordingMetrics()
+	cfg := config.ACMEConfig{
+		Hosts:        []string{"acme.example.com"},
+		CacheDir:     t.TempDir(),
+		DirectoryURL: "http://127.0.0.1:1/directory", // Nothing listens there
+	}
+	manager, err := NewACME(cfg, logging.Discard(), recorder)
+	require.NoError(t, err)
+
+	_, err = manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "ACME.example.com.", CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}})
+	assert.Error(t, err)
+	assert.Equal(t, map[string]int{"acme.example.com": 1}, recorder.errors)
+}
+
+// TestACME_Pebble obtains a certificate from a running Pebble test CA. It is
+// skipped unless PEBBLE_DIRECTORY points at Pebble's directory URL. Pebble
+// must be able to resolve PEBBLE_HOST to this machine and validate TLS-ALPN-01
+// challenges on PEBBLE_TLS_PORT (Pebble's tlsPort, 5001 by default).
+func TestACME_Pebble(t *testing.T) {
+	directory := os.Getenv("PEBBLE_DIRECTORY")
+	if directory == "" {
+		t.Skip("PEBBLE_DIRECTORY not set")
+	}
+	host := os.Getenv("PEBBLE_HOST")
+	if host == "" {
+		host = "gateway.example.com"
+	}
+	port := 5001
+	if value := os.Getenv("PEBBLE_TLS_PORT"); value != "" {
+		var err error
+		port, err = strconv.Atoi(value)
+		require.NoError(t, err)
+	}
+
+	cfg := config.TLSConfig{ACME: config.ACMEConfig{
+		Hosts:        []string{host},
+		CacheDir:     t.TempDir(),
+		DirectoryURL: directory,
+		CAFile:       os.Getenv("PEBBLE_CA_FILE"),
+	}}
+	recorder := newRecordingMetrics()
+	manager, err := NewACME(cfg.ACME, logging.Discard(), recorder)
+	require.NoError(t, err)
+	tlsConfig, err := NewTLSConfig(cfg, nil, manager)
+	require.NoError(t, err)
+
+	listener, err := tls.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)), tlsConfig)
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	cert, err := manager.GetCertificate(&tls.ClientHelloInfo{
+		ServerName:        host,
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+		SupportedVersions: []uint16{tls.VersionTLS13},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, cert.Leaf)
+	assert.NoError(t, cert.Leaf.VerifyHostname(host))
+	assert.Equal(t, 1, recorder.issued[host])
+}
@@ -0,0 +1,216 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/health"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+)
+
+const (
+	defaultReloadInterval = 10 * time.Second
+	expiryWarning         = 14 * 24 * time.Hour
+)
+
+// Store holds the certificates of the TLS listener, selects one per
+// connection by SNI and reloads them when the files change on disk
+type Store struct {
+	mu           sync.RWMutex
+	files        []config.Certificate
+	certificates []*tls.Certificate
+	modTimes     []time.Time
+	interval     time.Duration
+	logService   logging.Logger
+	stop         chan struct{}
+	stopOnce     sync.Once
+}
+
+// NewStore loads the configured certificates and starts watching their files
+func NewStore(cfg config.TLSConfig, logger logging.Logger) (*Store, error) {
+	if len(cfg.Certificates) == 0 {
+		return nil, errors.New("no certificates configured")
+	}
+
+	s := &Store{
+		files:      cfg.Certificates,
+		interval:   cfg.ReloadInterval.Or(defaultReloadInterval),
+		logService: logger,
+		stop:       make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	go s.watch()
+	return s, nil
+}
+
+// load reads every certificate pair, replacing the active set only when all
+// of them load successfully
+func (s *Store) load() error {
+	certificates := make([]*tls.Certificate, len(s.files))
+	modTimes := make([]time.Time, len(s.files))
+	for i, file := range s.files {
+		cert, err := tls.LoadX509KeyPair(file.CertFile, file.KeyFile)
+		if err == nil && cert.Leaf == nil {
+			cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		}
+		if err != nil {
+			return fmt.Errorf("loading certificate %s: %w", file.CertFile, err)
+		}
+		certificates[i] = &cert
+		modTimes[i] = latestModTime(file)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certificates = certificates
+	s.modTimes = modTimes
+	return nil
+}
+
+func (s *Store) watch() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if !s.changed() {
+				continue
+			}
+			if err := s.load(); err != nil {
+				// Keep serving the previous certificates until the files are fixed
+				s.logService.Errorf("TLS: error reloading certificates: %v", err)
+				continue
+			}
+			s.logService.Info("TLS: reloaded certificates")
+		}
+	}
+}
+
+func (s *Store) changed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i, file := range s.files {
+		if !latestModTime(file).Equal(s.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// latestModTime returns the newer modification time of a certificate and its key
+func latestModTime(file config.Certificate) time.Time {
+	var latest time.Time
+	for _, name := range []string{file.CertFile, file.KeyFile} {
+		if info, err := os.Stat(name); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// GetCertificate selects the certificate matching the client's SNI name,
+// preferring one the client supports, and falls back to the first configured
+// certificate. It is meant to be used as tls.Config.GetCertificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var match *tls.Certificate
+	for _, cert := range s.certificates {
+		if hello.ServerName != "" && cert.Leaf.VerifyHostname(hello.ServerName) != nil {
+			continue
+		}
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+		if match == nil {
+			match = cert
+		}
+	}
+	if match != nil {
+		return match, nil
+	}
+	return s.certificates[0], nil
+}
+
+// HealthCheck reports the expiry of every certificate, warning about the ones
+// that expire within expiryWarning and failing expired ones
+func (s *Store) HealthCheck(ctx context.Context) []health.Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	results := make([]health.Result, 0, len(s.certificates))
+	for i, cert := range s.certificates {
+		result := health.Result{
+			ComponentID:   s.files[i].CertFile,
+			ComponentType: "component",
+			ObservedValue: cert.Leaf.NotAfter.UTC().Format(time.RFC3339),
+			Status:        health.StatusPass,
+			Time:          health.Now(),
+		}
+		switch {
+		case now.After(cert.Leaf.NotAfter):
+			result.Status = health.StatusFail
+			result.Output = "certificate expired"
+		case now.Add(expiryWarning).After(cert.Leaf.NotAfter):
+			result.Status = health.StatusWarn
+			result.Output = "certificate expires soon"
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Close stops watching the certificate files
+func (s *Store) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// NewTLSConfig builds the server TLS configuration for the listener. Either
+// store or manager may be nil; when both are set, ACME hosts and challenges
+// are served by manager and everything else by store.
+func NewTLSConfig(cfg config.TLSConfig, store *Store, manager *ACME) (*tls.Config, error) {
+	minVersion, err := cfg.TLSVersion()
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := cfg.CipherSuiteIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+		NextProtos:   []string{"h2", "http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if manager != nil && (store == nil || manager.Handles(hello)) {
+				return manager.GetCertificate(hello)
+			}
+			if store != nil {
+				return store.GetCertificate(hello)
+			}
+			return nil, errors.New("no certificates configured")
+		},
+	}
+	if manager != nil {
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,155 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+)
+
+// writeCertificate writes a self-signed certificate for host into dir and
+// returns its file pair and serial number
+func writeCertificate(t *testing.T, dir, host string, serial int64) config.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	file := config.Certificate{
+		CertFile: filepath.Join(dir, host+".crt"),
+		KeyFile:  filepath.Join(dir, host+".key"),
+	}
+	require.NoError(t, os.WriteFile(file.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(file.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return file
+}
+
+func serialOf(t *testing.T, cert *tls.Certificate) int64 {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.SerialNumber.Int64()
+}
+
+func TestStore_SelectsCertificateBySNI(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TLSConfig{Certificates: []config.Certificate{
+		writeCertificate(t, dir, "a.example.com", 1),
+		writeCertificate(t, dir, "b.example.com", 2),
+	}}
+
+	store, err := NewStore(cfg, logging.Discard())
+	require.NoError(t, err)
+	defer store.Close()
+
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "b.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), serialOf(t, cert))
+
+	cert, err = store.GetCertificate(&tls.ClientHelloInfo{ServerName: "unknown.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), serialOf(t, cert))
+}
+
+func TestStore_ReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TLSConfig{
+		Certificates:   []config.Certificate{writeCertificate(t, dir, "a.example.com", 1)},
+		ReloadInterval: config.Duration(10 * time.Millisecond),
+	}
+
+	store, err := NewStore(cfg, logging.Discard())
+	require.NoError(t, err)
+	defer store.Close()
+
+	// A broken file keeps the previous certificate in place
+	require.NoError(t, os.WriteFile(cfg.Certificates[0].CertFile, []byte("garbage"), 0o600))
+	time.Sleep(50 * time.Millisecond)
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), serialOf(t, cert))
+
+	writeCertificate(t, dir, "a.example.com", 7)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(cfg.Certificates[0].CertFile, future, future))
+
+	require.Eventually(t, func() bool {
+		cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.example.com"})
+		return err == nil && serialOf(t, cert) == 7
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNewStore_MissingFiles(t *testing.T) {
+	_, err := NewStore(config.TLSConfig{Certificates: []config.Certificate{{CertFile: "missing.crt", KeyFile: "missing.key"}}}, logging.Discard())
+	assert.Error(t, err)
+}
+
+func TestNewTLSConfig_EnforcesMinVersion(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TLSConfig{
+		Certificates: []config.Certificate{writeCertificate(t, dir, "a.example.com", 1)},
+		MinVersion:   "1.3",
+	}
+	store, err := NewStore(cfg, logging.Discard())
+	require.NoError(t, err)
+	defer store.Close()
+
+	tlsConfig, err := NewTLSConfig(cfg, store, nil)
+	require.NoError(t, err)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	dial := func(maxVersion uint16) error {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", listener.Addr().String(), &tls.Config{
+			ServerName:         "a.example.com",
+			InsecureSkipVerify: true,
+			MaxVersion:         maxVersion,
+		})
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	assert.NoError(t, dial(tls.VersionTLS13))
+	assert.Error(t, dial(tls.VersionTLS12))
+}
@@ -0,0 +1,110 @@
+// Package clientip resolves the address of the client behind a chain of
+// trusted proxies and carries it in the request context
+package clientip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type contextKey struct{}
+
+// Client is where a request came from
+type Client struct {
+	// IP is the resolved client address, without a port
+	IP string
+	// Peer is the address of the direct peer, without a port
+	Peer string
+	// TrustedPeer is true when the peer is a trusted proxy, whose forwarding
+	// headers may be passed on
+	TrustedPeer bool
+}
+
+// Resolver finds the client IP of requests, believing X-Forwarded-For entries
+// added by trusted proxies only
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// NewResolver creates a resolver trusting the proxies in the given ranges
+func NewResolver(trusted []*net.IPNet) *Resolver {
+	return &Resolver{trusted: trusted}
+}
+
+// Trusted reports whether ip belongs to a trusted proxy
+func (res *Resolver) Trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range res.trusted {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client of r. Walking X-Forwarded-For from the right,
+// the first hop that is not a trusted proxy is the client; entries left of it
+// could have been sent by the client itself.
+func (res *Resolver) Resolve(r *http.Request) Client {
+	peer := PeerIP(r.RemoteAddr)
+	client := Client{IP: peer, Peer: peer, TrustedPeer: res.Trusted(peer)}
+	if !client.TrustedPeer {
+		return client
+	}
+
+	hops := ForwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		client.IP = hops[i]
+		if !res.Trusted(hops[i]) {
+			break
+		}
+	}
+	return client
+}
+
+// PeerIP strips the port from a RemoteAddr
+func PeerIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// ForwardedFor returns the hops listed in all X-Forwarded-For headers, in order
+func ForwardedFor(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, PeerIP(hop))
+			}
+		}
+	}
+	return hops
+}
+
+// NewContext returns a copy of ctx carrying the client
+func NewContext(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, contextKey{}, client)
+}
+
+// FromContext returns the client stored in ctx
+func FromContext(ctx context.Context) (Client, bool) {
+	client, ok := ctx.Value(contextKey{}).(Client)
+	return client, ok
+}
+
+// FromRequest returns the resolved client of r, or its direct peer when the
+// client was not resolved
+func FromRequest(r *http.Request) Client {
+	if client, ok := FromContext(r.Context()); ok {
+		return client
+	}
+	peer := PeerIP(r.RemoteAddr)
+	return Client{IP: peer, Peer: peer}
+}
@@ -0,0 +1,62 @@
+package clientip
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustCIDR(t *testing.T, cidr string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ipNet
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	resolver := NewResolver([]*net.IPNet{mustCIDR(t, "10.0.0.0/8"), mustCIDR(t, "2001:db8::/32")})
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		forwardedFor  []string
+		expected      string
+		expectTrusted bool
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.7:1234", expected: "203.0.113.7"},
+		{name: "spoofed by untrusted peer", remoteAddr: "203.0.113.7:1234", forwardedFor: []string{"198.51.100.1"}, expected: "203.0.113.7"},
+		{name: "trusted proxy without header", remoteAddr: "10.0.0.1:1234", expected: "10.0.0.1", expectTrusted: true},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.1"}, expected: "198.51.100.1", expectTrusted: true},
+		{name: "right-most untrusted hop", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"1.1.1.1, 198.51.100.1", "10.0.0.2"}, expected: "198.51.100.1", expectTrusted: true},
+		{name: "only trusted hops", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"10.0.0.3, 10.0.0.2"}, expected: "10.0.0.3", expectTrusted: true},
+		{name: "ipv6 proxy", remoteAddr: "[2001:db8::1]:1234", forwardedFor: []string{"[2001:db9::5]:4711"}, expected: "2001:db9::5", expectTrusted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			client := resolver.Resolve(r)
+			assert.Equal(t, tt.expected, client.IP)
+			assert.Equal(t, PeerIP(tt.remoteAddr), client.Peer)
+			assert.Equal(t, tt.expectTrusted, client.TrustedPeer)
+		})
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	assert.Equal(t, Client{IP: "203.0.113.7", Peer: "203.0.113.7"}, FromRequest(r))
+
+	client := Client{IP: "198.51.100.1", Peer: "10.0.0.1", TrustedPeer: true}
+	r = r.WithContext(NewContext(context.Background(), client))
+	assert.Equal(t, client, FromRequest(r))
+}
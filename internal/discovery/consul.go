@@ -0,0 +1,186 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+)
+
+const (
+	defaultConsulAddress  = "http://127.0.0.1:8500"
+	defaultConsulWaitTime = 5 * time.Minute
+	consulMinRetryDelay   = time.Second
+	consulMaxRetryDelay   = 30 * time.Second
+)
+
+// consulServiceEntry is the subset of a /v1/health/service entry the provider
+// needs
+type consulServiceEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string   `json:"Address"`
+		Port    int      `json:"Port"`
+		Tags    []string `json:"Tags"`
+	} `json:"Service"`
+}
+
+// ConsulProvider keeps the healthy instances of a service current using
+// blocking queries against Consul's health API
+type ConsulProvider struct {
+	address    string
+	token      string
+	datacenter string
+	waitTime   time.Duration
+	client     *http.Client
+	logService logging.Logger
+}
+
+// NewConsulProvider creates a Consul provider
+func NewConsulProvider(cfg config.ConsulDiscoveryConfig, logger logging.Logger) *ConsulProvider {
+	address := cfg.Address
+	if address == "" {
+		address = defaultConsulAddress
+	}
+
+	return &ConsulProvider{
+		address:    strings.TrimSuffix(address, "/"),
+		token:      cfg.Token,
+		datacenter: cfg.Datacenter,
+		waitTime:   cfg.WaitTime.Or(defaultConsulWaitTime),
+		client:     &http.Client{},
+		logService: logger,
+	}
+}
+
+// Watch implements Provider
+func (p *ConsulProvider) Watch(ctx context.Context, service config.Service, update func([]Endpoint)) {
+	var index uint64
+	retryDelay := consulMinRetryDelay
+
+	for ctx.Err() == nil {
+		endpoints, nextIndex, err := p.query(ctx, service, index)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// Keep the last known endpoints and back off until Consul answers again
+			p.logService.Warnf("Discovery: error querying Consul for service %s: %v", service.Name, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			retryDelay = min(retryDelay*2, consulMaxRetryDelay)
+			continue
+		}
+		retryDelay = consulMinRetryDelay
+
+		// Consul may return without changes when the wait time elapses
+		if index == 0 || nextIndex != index {
+			update(endpoints)
+		}
+
+		// An index that goes backwards means the Consul state was reset
+		if nextIndex < index {
+			nextIndex = 0
+		}
+		index = nextIndex
+
+		// Without an index the next query would not block, so pace it
+		if index == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(consulMinRetryDelay):
+			}
+		}
+	}
+}
+
+// query runs a single blocking query, returning the healthy endpoints and the
+// index to use for the next query
+func (p *ConsulProvider) query(ctx context.Context, service config.Service, index uint64) ([]Endpoint, uint64, error) {
+	params := url.Values{}
+	params.Set("passing", "true")
+	params.Set("wait", p.waitTime.String())
+	if index > 0 {
+		params.Set("index", strconv.FormatUint(index, 10))
+	}
+	if dc := service.Datacenter; dc != "" {
+		params.Set("dc", dc)
+	} else if p.datacenter != "" {
+		params.Set("dc", p.datacenter)
+	}
+	for _, tag := range service.Tags {
+		params.Add("tag", tag)
+	}
+
+	// Consul adds up to wait/16 of jitter to blocking queries
+	ctx, cancel := context.WithTimeout(ctx, p.waitTime+p.waitTime/16+10*time.Second)
+	defer cancel()
+
+	reqURL := p.address + "/v1/health/service/" + url.PathEscape(service.Name) + "?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if p.token != "" {
+		req.Header.Set("X-Consul-Token", p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, err
+	}
+	nextIndex, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	endpoints := make([]Endpoint, 0, len(entries))
+	for _, entry := range entries {
+		// Filter on our side too, older Consul versions only honour one tag
+		if !hasTags(entry.Service.Tags, service.Tags) {
+			continue
+		}
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		endpoints = append(endpoints, Endpoint{Host: host, Port: entry.Service.Port})
+	}
+
+	// Keep a stable order so unchanged instance sets are recognized
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Address() < endpoints[j].Address()
+	})
+	return endpoints, nextIndex, nil
+}
+
+func hasTags(tags, required []string) bool {
+	for _, tag := range required {
+		if !slices.Contains(tags, tag) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+)
+
+// consulStub serves /v1/health/service with blocking query semantics
+type consulStub struct {
+	mu      sync.Mutex
+	changed chan struct{}
+	index   uint64
+	entries []map[string]interface{}
+	queries []*http.Request
+}
+
+func newConsulStub(t *testing.T) (*consulStub, *httptest.Server) {
+	stub := &consulStub{changed: make(chan struct{}), index: 1}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return stub, server
+}
+
+func (s *consulStub) setInstances(instances ...map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = instances
+	s.index++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func instance(address string, port int, tags ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"Node":    map[string]interface{}{"Address": "10.0.0.100"},
+		"Service": map[string]interface{}{"Address": address, "Port": port, "Tags": tags},
+	}
+}
+
+func (s *consulStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.queries = append(s.queries, r)
+	index, changed := s.index, s.changed
+	s.mu.Unlock()
+
+	if requested, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); requested == index {
+		wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(s.index, 10))
+	json.NewEncoder(w).Encode(s.entries)
+}
+
+func (s *consulStub) lastQuery() *http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[len(s.queries)-1]
+}
+
+func TestConsulProvider_BlockingQueries(t *testing.T) {
+	stub, server := newConsulStub(t)
+	stub.setInstances(
+		instance("10.0.0.1", 8081, "primary"),
+		instance("", 8082, "primary", "canary"),
+		instance("10.0.0.3", 8083, "secondary"),
+	)
+
+	registry := NewRegistry(config.DiscoveryConfig{
+		Consul: config.ConsulDiscoveryConfig{
+			Address:    server.URL,
+			Token:      "acl-token",
+			Datacenter: "dc1",
+			WaitTime:   config.Duration(time.Second),
+		},
+	}, logging.Discard())
+	defer registry.Stop()
+	registry.Sync([]config.Service{{Name: "user-service", Provider: config.ProviderConsul, Tags: []string{"primary"}, Datacenter: "dc2"}})
+
+	require.Eventually(t, func() bool {
+		return len(registry.Endpoints("user-service")) == 2
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, []Endpoint{{Host: "10.0.0.100", Port: 8082}, {Host: "10.0.0.1", Port: 8081}}, registry.Endpoints("user-service"))
+
+	query := stub.lastQuery()
+	assert.Equal(t, "/v1/health/service/user-service", query.URL.Path)
+	assert.Equal(t, "true", query.URL.Query().Get("passing"))
+	assert.Equal(t, "dc2", query.URL.Query().Get("dc"))
+	assert.Equal(t, []string{"primary"}, query.URL.Query()["tag"])
+	assert.Equal(t, "acl-token", query.Header.Get("X-Consul-Token"))
+
+	// A blocking query returns as soon as the instances change
+	stub.setInstances(instance("10.0.0.4", 8084, "primary"))
+	require.Eventually(t, func() bool {
+		endpoints := registry.Endpoints("user-service")
+		return len(endpoints) == 1 && endpoints[0].Host == "10.0.0.4"
+	}, 500*time.Millisecond, 5*time.Millisecond)
+	assert.NotEmpty(t, stub.lastQuery().URL.Query().Get("index"))
+}
+
+func TestConsulProvider_KeepsEndpointsOnError(t *testing.T) {
+	stub, server := newConsulStub(t)
+	stub.setInstances(instance("10.0.0.1", 8081))
+
+	provider := NewConsulProvider(config.ConsulDiscoveryConfig{Address: server.URL, WaitTime: config.Duration(time.Second)}, logging.Discard())
+	endpoints, index, err := provider.query(context.Background(), config.Service{Name: "orders"}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), index)
+	assert.Len(t, endpoints, 1)
+
+	server.Close()
+	_, _, err = provider.query(context.Background(), config.Service{Name: "orders"}, index)
+	assert.Error(t, err)
+}
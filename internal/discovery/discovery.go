@@ -0,0 +1,181 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/health"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+)
+
+// Endpoint is a single network address serving a service
+type Endpoint struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// Address returns the endpoint as host:port
+func (e Endpoint) Address() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// Provider resolves the endpoints of a service
+type Provider interface {
+	// Watch calls update with the endpoints of service every time they change,
+	// until ctx is cancelled
+	Watch(ctx context.Context, service config.Service, update func([]Endpoint))
+}
+
+type serviceState struct {
+	config    config.Service
+	cancel    context.CancelFunc
+	endpoints atomic.Pointer[[]Endpoint]
+	next      atomic.Uint64
+}
+
+// Registry keeps the endpoint sets of discovered services up to date
+type Registry struct {
+	mu         sync.RWMutex
+	providers  map[string]Provider
+	services   map[string]*serviceState
+	logService logging.Logger
+}
+
+// NewRegistry creates a registry with the providers configured in cfg
+func NewRegistry(cfg config.DiscoveryConfig, logger logging.Logger) *Registry {
+	return &Registry{
+		providers: map[string]Provider{
+			config.ProviderFile:   NewFileProvider(cfg.File, logger),
+			config.ProviderDNS:    NewDNSProvider(cfg.DNS, logger),
+			config.ProviderConsul: NewConsulProvider(cfg.Consul, logger),
+		},
+		services:   make(map[string]*serviceState),
+		logService: logger,
+	}
+}
+
+// RegisterProvider adds or replaces the provider used for services with the
+// given provider name
+func (r *Registry) RegisterProvider(name string, provider Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[name] = provider
+}
+
+// Sync starts watching new or changed services and stops watching services
+// that were removed. Endpoints of unchanged services are kept.
+func (r *Registry) Sync(services []config.Service) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wanted := make(map[string]config.Service, len(services))
+	for _, service := range services {
+		wanted[service.Name] = service
+	}
+
+	for name, state := range r.services {
+		if service, ok := wanted[name]; !ok || !reflect.DeepEqual(service, state.config) {
+			state.cancel()
+			delete(r.services, name)
+		}
+	}
+
+	for name, service := range wanted {
+		if _, ok := r.services[name]; ok {
+			continue
+		}
+		provider, ok := r.providers[service.Provider]
+		if !ok {
+			r.logService.Errorf("Discovery: no provider %q for service %s", service.Provider, name)
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		state := &serviceState{config: service, cancel: cancel}
+		r.services[name] = state
+		go provider.Watch(ctx, service, func(endpoints []Endpoint) {
+			r.update(state, endpoints)
+		})
+	}
+}
+
+func (r *Registry) update(state *serviceState, endpoints []Endpoint) {
+	if current := state.endpoints.Load(); current != nil && slices.Equal(*current, endpoints) {
+		return
+	}
+	state.endpoints.Store(&endpoints)
+	r.logService.Infof("Discovery: service %s has %d endpoints", state.config.Name, len(endpoints))
+}
+
+// Endpoints returns the current endpoints of a service
+func (r *Registry) Endpoints(name string) []Endpoint {
+	state := r.state(name)
+	if state == nil {
+		return nil
+	}
+	if endpoints := state.endpoints.Load(); endpoints != nil {
+		return *endpoints
+	}
+	return nil
+}
+
+// Next picks an endpoint of a service in round-robin order
+func (r *Registry) Next(name string) (Endpoint, bool) {
+	state := r.state(name)
+	if state == nil {
+		return Endpoint{}, false
+	}
+	endpoints := state.endpoints.Load()
+	if endpoints == nil || len(*endpoints) == 0 {
+		return Endpoint{}, false
+	}
+	i := state.next.Add(1) - 1
+	return (*endpoints)[i%uint64(len(*endpoints))], true
+}
+
+func (r *Registry) state(name string) *serviceState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.services[name]
+}
+
+// HealthCheck reports the number of endpoints of every discovered service,
+// failing the ones that have none
+func (r *Registry) HealthCheck(ctx context.Context) []health.Result {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.services))
+	for name := range r.services {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+
+	results := make([]health.Result, 0, len(names))
+	for _, name := range names {
+		result := health.Result{
+			ComponentID:   name,
+			ComponentType: "component",
+			ObservedValue: len(r.Endpoints(name)),
+			ObservedUnit:  "endpoints",
+			Status:        health.StatusPass,
+			Time:          health.Now(),
+		}
+		if result.ObservedValue == 0 {
+			result.Status = health.StatusFail
+			result.Output = "no endpoints discovered"
+		}
+		results = append(results, result)
+	}
+	health.SortResults(results)
+	return results
+}
+
+// Stop stops watching all services
+func (r *Registry) Stop() {
+	r.Sync(nil)
+}
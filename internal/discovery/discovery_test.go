@@ -0,0 +1,176 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+)
+
+func TestRegistry_FileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("user-service:\n  - 10.0.0.1:8081\n  - 10.0.0.2:8081\n"), 0o644))
+
+	registry := NewRegistry(config.DiscoveryConfig{
+		File: config.FileDiscoveryConfig{Path: path, Interval: config.Duration(10 * time.Millisecond)},
+	}, logging.Discard())
+	defer registry.Stop()
+	registry.Sync([]config.Service{{Name: "user-service", Provider: config.ProviderFile}})
+
+	require.Eventually(t, func() bool {
+		return len(registry.Endpoints("user-service")) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	first, ok := registry.Next("user-service")
+	require.True(t, ok)
+	second, _ := registry.Next("user-service")
+	third, _ := registry.Next("user-service")
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, first, third)
+
+	// Changes to the file are picked up
+	require.NoError(t, os.WriteFile(path, []byte("user-service:\n  - 10.0.0.3:9000\n"), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	require.Eventually(t, func() bool {
+		endpoints := registry.Endpoints("user-service")
+		return len(endpoints) == 1 && endpoints[0] == Endpoint{Host: "10.0.0.3", Port: 9000}
+	}, time.Second, 5*time.Millisecond)
+
+	registry.Sync(nil)
+	_, ok = registry.Next("user-service")
+	assert.False(t, ok)
+}
+
+func TestFileProvider_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"orders": ["[::1]:8083"]}`), 0o644))
+
+	provider := NewFileProvider(config.FileDiscoveryConfig{Path: path}, logging.Discard())
+	services, err := provider.load()
+	require.NoError(t, err)
+	assert.Equal(t, []Endpoint{{Host: "::1", Port: 8083}}, services["orders"])
+	assert.Equal(t, "[::1]:8083", services["orders"][0].Address())
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"orders": ["no-port"]}`), 0o644))
+	_, err = provider.load()
+	assert.Error(t, err)
+}
+
+// dnsStub answers A and SRV queries from fixed records over UDP
+type dnsStub struct {
+	mu   sync.Mutex
+	a    map[string][]net.IP
+	srv  map[string][]dnsmessage.SRVResource
+	conn net.PacketConn
+}
+
+func newDNSStub(t *testing.T) *dnsStub {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	stub := &dnsStub{a: map[string][]net.IP{}, srv: map[string][]dnsmessage.SRVResource{}, conn: conn}
+	t.Cleanup(func() { conn.Close() })
+	go stub.serve()
+	return stub
+}
+
+func (s *dnsStub) setA(name string, ips ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.a[name] = nil
+	for _, ip := range ips {
+		s.a[name] = append(s.a[name], net.ParseIP(ip))
+	}
+}
+
+func (s *dnsStub) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buf[:n])
+		if err != nil {
+			continue
+		}
+		question, err := parser.Question()
+		if err != nil {
+			continue
+		}
+
+		builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true})
+		builder.EnableCompression()
+		builder.StartQuestions()
+		builder.Question(question)
+		builder.StartAnswers()
+		answer := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 1}
+
+		s.mu.Lock()
+		switch question.Type {
+		case dnsmessage.TypeA:
+			for _, ip := range s.a[question.Name.String()] {
+				var a dnsmessage.AResource
+				copy(a.A[:], ip.To4())
+				builder.AResource(answer, a)
+			}
+		case dnsmessage.TypeSRV:
+			for _, srv := range s.srv[question.Name.String()] {
+				builder.SRVResource(answer, srv)
+			}
+		}
+		s.mu.Unlock()
+
+		msg, err := builder.Finish()
+		if err != nil {
+			continue
+		}
+		s.conn.WriteTo(msg, addr)
+	}
+}
+
+func TestDNSProvider_ARecords(t *testing.T) {
+	stub := newDNSStub(t)
+	stub.setA("user-service.test.", "10.0.0.2", "10.0.0.1")
+
+	registry := NewRegistry(config.DiscoveryConfig{
+		DNS: config.DNSDiscoveryConfig{TTL: config.Duration(10 * time.Millisecond), Resolver: stub.conn.LocalAddr().String()},
+	}, logging.Discard())
+	defer registry.Stop()
+	registry.Sync([]config.Service{{Name: "users", Provider: config.ProviderDNS, Host: "user-service.test", Port: 8081}})
+
+	require.Eventually(t, func() bool {
+		return len(registry.Endpoints("users")) == 2
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, []Endpoint{{Host: "10.0.0.1", Port: 8081}, {Host: "10.0.0.2", Port: 8081}}, registry.Endpoints("users"))
+
+	// Records are re-resolved after the TTL
+	stub.setA("user-service.test.", "10.0.0.9")
+	require.Eventually(t, func() bool {
+		endpoints := registry.Endpoints("users")
+		return len(endpoints) == 1 && endpoints[0].Host == "10.0.0.9"
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestDNSProvider_SRVRecords(t *testing.T) {
+	stub := newDNSStub(t)
+	stub.srv["_http._tcp.orders.test."] = []dnsmessage.SRVResource{
+		{Priority: 10, Weight: 5, Port: 8083, Target: dnsmessage.MustNewName("orders-1.test.")},
+		{Priority: 10, Weight: 5, Port: 8084, Target: dnsmessage.MustNewName("orders-2.test.")},
+	}
+
+	provider := NewDNSProvider(config.DNSDiscoveryConfig{Resolver: stub.conn.LocalAddr().String()}, logging.Discard())
+	endpoints, err := provider.resolve(context.Background(), config.Service{Name: "orders", Provider: config.ProviderDNS, SRV: "_http._tcp.orders.test"})
+	require.NoError(t, err)
+	assert.Equal(t, []Endpoint{{Host: "orders-1.test", Port: 8083}, {Host: "orders-2.test", Port: 8084}}, endpoints)
+}
@@ -0,0 +1,105 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+)
+
+const (
+	defaultDNSTTL    = 30 * time.Second
+	dnsLookupTimeout = 5 * time.Second
+	dnsDialTimeout   = 2 * time.Second
+)
+
+// DNSProvider resolves services from A/AAAA records combined with a fixed
+// port, or from SRV records, re-resolving them every TTL
+type DNSProvider struct {
+	resolver   *net.Resolver
+	ttl        time.Duration
+	logService logging.Logger
+}
+
+// NewDNSProvider creates a DNS provider. Lookups go to cfg.Resolver when set,
+// otherwise to the system resolver.
+func NewDNSProvider(cfg config.DNSDiscoveryConfig, logger logging.Logger) *DNSProvider {
+	resolver := net.DefaultResolver
+	if cfg.Resolver != "" {
+		address := cfg.Resolver
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				dialer := net.Dialer{Timeout: dnsDialTimeout}
+				return dialer.DialContext(ctx, network, address)
+			},
+		}
+	}
+
+	return &DNSProvider{
+		resolver:   resolver,
+		ttl:        cfg.TTL.Or(defaultDNSTTL),
+		logService: logger,
+	}
+}
+
+// Watch implements Provider
+func (p *DNSProvider) Watch(ctx context.Context, service config.Service, update func([]Endpoint)) {
+	ticker := time.NewTicker(p.ttl)
+	defer ticker.Stop()
+
+	for {
+		endpoints, err := p.resolve(ctx, service)
+		if err != nil {
+			// Keep the last known endpoints until the records resolve again
+			if ctx.Err() == nil {
+				p.logService.Warnf("Discovery: error resolving service %s: %v", service.Name, err)
+			}
+		} else {
+			update(endpoints)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *DNSProvider) resolve(ctx context.Context, service config.Service) ([]Endpoint, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+	defer cancel()
+
+	var endpoints []Endpoint
+	if service.SRV != "" {
+		_, records, err := p.resolver.LookupSRV(ctx, "", "", service.SRV)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			endpoints = append(endpoints, Endpoint{
+				Host: strings.TrimSuffix(record.Target, "."),
+				Port: int(record.Port),
+			})
+		}
+	} else {
+		addresses, err := p.resolver.LookupIPAddr(ctx, service.Host)
+		if err != nil {
+			return nil, err
+		}
+		for _, address := range addresses {
+			endpoints = append(endpoints, Endpoint{Host: address.IP.String(), Port: service.Port})
+		}
+	}
+
+	// Keep a stable order so unchanged record sets are recognized
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Address() < endpoints[j].Address()
+	})
+	return endpoints, nil
+}
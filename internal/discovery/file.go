@@ -0,0 +1,114 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+)
+
+const defaultFileInterval = 5 * time.Second
+
+// FileProvider reads service endpoints from a JSON or YAML file mapping
+// service names to lists of "host:port" addresses, e.g.
+//
+//	user-service:
+//	  - 10.0.0.1:8081
+//	  - 10.0.0.2:8081
+//
+// The file is checked for changes at a fixed interval.
+type FileProvider struct {
+	path       string
+	interval   time.Duration
+	logService logging.Logger
+}
+
+// NewFileProvider creates a file provider
+func NewFileProvider(cfg config.FileDiscoveryConfig, logger logging.Logger) *FileProvider {
+	return &FileProvider{
+		path:       cfg.Path,
+		interval:   cfg.Interval.Or(defaultFileInterval),
+		logService: logger,
+	}
+}
+
+// Watch implements Provider
+func (p *FileProvider) Watch(ctx context.Context, service config.Service, update func([]Endpoint)) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	var modTime time.Time
+	for {
+		info, err := os.Stat(p.path)
+		if err != nil {
+			p.logService.Warnf("Discovery: error reading %s: %v", p.path, err)
+		} else if !info.ModTime().Equal(modTime) {
+			services, err := p.load()
+			if err != nil {
+				p.logService.Warnf("Discovery: error reading %s: %v", p.path, err)
+			} else {
+				modTime = info.ModTime()
+				update(services[service.Name])
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *FileProvider) load() (map[string][]Endpoint, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses map[string][]string
+	if strings.EqualFold(filepath.Ext(p.path), ".json") {
+		err = json.Unmarshal(data, &addresses)
+	} else {
+		err = yaml.Unmarshal(data, &addresses)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	services := make(map[string][]Endpoint, len(addresses))
+	for name, list := range addresses {
+		endpoints := make([]Endpoint, 0, len(list))
+		for _, address := range list {
+			endpoint, err := parseEndpoint(address)
+			if err != nil {
+				return nil, fmt.Errorf("service %s: %w", name, err)
+			}
+			endpoints = append(endpoints, endpoint)
+		}
+		services[name] = endpoints
+	}
+	return services, nil
+}
+
+func parseEndpoint(address string) (Endpoint, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil || port <= 0 || port > 65535 {
+		return Endpoint{}, fmt.Errorf("invalid port in %q", address)
+	}
+	return Endpoint{Host: host, Port: port}, nil
+}
@@ -2,77 +2,334 @@ package gateway
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/certs"
+	"github.com/leo-andrei/api-gateway/internal/clientip"
+	"github.com/leo-andrei/api-gateway/internal/discovery"
+	"github.com/leo-andrei/api-gateway/internal/health"
 	"github.com/leo-andrei/api-gateway/internal/logging"
 	"github.com/leo-andrei/api-gateway/internal/metrics"
 	"github.com/leo-andrei/api-gateway/internal/middleware"
+	"github.com/leo-andrei/api-gateway/internal/tracing"
 )
 
+const defaultAltSvcMaxAge = 24 * time.Hour
+
 // Gateway represents the API gateway
 type Gateway struct {
 	config         *config.Config
 	router         *mux.Router
+	routes         atomic.Pointer[mux.Router]
 	server         *http.Server
+	tlsServer      *http.Server
+	quicServer     *http3.Server
+	certificates   *certs.Store
+	discovery      *discovery.Registry
+	clientIPs      *clientip.Resolver
+	inFlight       *inFlightTracker
+	draining       atomic.Bool
+	listening      atomic.Bool
+	active         atomic.Pointer[config.Config]
+	health         *health.Registry
 	logService     logging.Logger
 	metricsService metrics.Metrics
+	tracing        *tracing.Tracing
 }
 
-// NewGateway initializes a new API gateway
-func NewGateway(cfg *config.Config, logger logging.Logger, metrics metrics.Metrics) *Gateway {
+// NewGateway initializes a new API gateway. tracer may be nil when tracing is
+// disabled.
+func NewGateway(cfg *config.Config, logger logging.Logger, metrics metrics.Metrics, tracer *tracing.Tracing) *Gateway {
 	router := mux.NewRouter()
+	// The ranges were checked when the configuration was loaded
+	trustedProxies, _ := config.ParseCIDRs(cfg.Server.TrustedProxies)
 
-	return &Gateway{
+	g := &Gateway{
 		config:         cfg,
 		router:         router,
+		discovery:      discovery.NewRegistry(cfg.Discovery, logger),
+		clientIPs:      clientip.NewResolver(trustedProxies),
+		inFlight:       newInFlightTracker(metrics),
+		health:         health.NewRegistry(cfg.Health.Timeout.Std()),
 		logService:     logger,
 		metricsService: metrics,
+		tracing:        tracer,
 	}
+	g.health.Register("upstreams:reachability", g.checkUpstreams)
+	g.health.Register("discovery:endpoints", g.discovery.HealthCheck)
+	return g
 }
 
 // SetupRoutes configures the routes for the gateway
 func (g *Gateway) SetupRoutes() {
-	// Add metrics endpoint
-	g.router.Handle("/metrics", promhttp.Handler())
+	// Resolve the client behind trusted proxies before any handler runs
+	g.router.Use(func(next http.Handler) http.Handler {
+		return middleware.ClientIPMiddleware(next, g.clientIPs)
+	})
+	g.router.Use(func(next http.Handler) http.Handler {
+		return middleware.RequestIDMiddleware(next, g.config.RequestID)
+	})
+	g.router.Use(func(next http.Handler) http.Handler {
+		return middleware.LoggingMiddleware(next, g.logService)
+	})
+	g.router.Use(g.inFlight.Middleware)
+
+	// Add metrics endpoint, served from the metrics service's own registry
+	if exporter, ok := g.metricsService.(interface{ Handler() http.Handler }); ok {
+		g.router.Handle("/metrics", g.observe(exporter.Handler()))
+	}
+
+	// Add health endpoints, unless they are served on the admin port
+	if !g.config.Health.AdminPort {
+		g.healthRoutes(g.router)
+	}
+
+	// Configured routes live in their own router so they can be replaced at runtime
+	g.ApplyConfig(g.config)
+	g.router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.routes.Load().ServeHTTP(w, r)
+	})
+}
 
-	// Add health check endpoint
-	g.router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "OK")
-	}).Methods("GET")
+// ApplyConfig replaces the proxied routes with the ones in cfg. Requests that
+// are already in flight finish on the routes they were matched against.
+func (g *Gateway) ApplyConfig(cfg *config.Config) {
+	g.discovery.Sync(cfg.Services)
+	g.routes.Store(g.buildRoutes(cfg))
+	g.active.Store(cfg)
+}
+
+func (g *Gateway) buildRoutes(cfg *config.Config) *mux.Router {
+	router := mux.NewRouter()
 
 	// Configure routes from config
-	for _, route := range g.config.Routes {
+	for _, route := range cfg.Routes {
+		route.TargetURL = cfg.TargetURL(route)
+
 		// Create handler with auth middleware if required
-		handler := http.Handler(CreateProxyHandler(route))
+		var handler http.Handler
+		if i := cfg.FindService(route.Service); route.Service != "" && i >= 0 {
+			handler = CreateDiscoveryProxyHandler(route, cfg.Services[i], g.discovery, g.metricsService, g.tracing)
+		} else {
+			handler = CreateProxyHandler(route, g.metricsService, g.tracing)
+		}
 		if route.RequireAuth {
 			handler = middleware.AuthMiddleware(handler)
 		}
 
-		// Apply metrics middleware
-		handler = middleware.MetricsMiddleware(handler, g.metricsService, g.logService)
+		// Apply metrics and tracing middleware
+		handler = g.instrument(handler, cfg)
 
 		// Register route
-		g.router.Handle(route.Path, handler).Methods(route.Method)
+		router.Handle(route.Path, handler).Methods(route.Method).Name(route.Name)
 	}
+
+	// Requests matching no route are measured under the unmatched path
+	router.NotFoundHandler = g.instrument(http.NotFoundHandler(), cfg)
+	router.MethodNotAllowedHandler = g.instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}), cfg)
+
+	return router
 }
 
-// Run starts the gateway server
+// observe wraps one of the gateway's own endpoints, such as /metrics and the
+// health checks, with the metrics middleware. They are measured and access
+// logged like proxied requests, and logging.accessLog.exclude can leave them
+// out of the access log.
+func (g *Gateway) observe(handler http.Handler) http.Handler {
+	return middleware.MetricsMiddleware(handler, g.metricsService, g.logService, g.config.Metrics.UnmatchedPath)
+}
+
+// instrument wraps a route's handler with the metrics and tracing middleware;
+// the server span encloses everything else done for the request
+func (g *Gateway) instrument(handler http.Handler, cfg *config.Config) http.Handler {
+	handler = middleware.MetricsMiddleware(handler, g.metricsService, g.logService, cfg.Metrics.UnmatchedPath)
+	return middleware.TracingMiddleware(handler, g.tracing)
+}
+
+// Run starts the gateway's plain listeners, plus the TLS and HTTP/3 listeners
+// when they are configured. It returns when any listener stops.
 func (g *Gateway) Run() error {
-	g.server = &http.Server{
-		Addr:    fmt.Sprintf(":%d", g.config.Server.Port),
-		Handler: g.router,
+	serverCfg := g.config.Server
+	g.server = NewHTTPServer(serverCfg, nil)
+
+	var handler http.Handler = g.router
+	var tlsConfig *tls.Config
+	if tlsCfg := serverCfg.TLS; tlsCfg.Enabled() {
+		var manager *certs.ACME
+		if tlsCfg.ACME.Enabled() {
+			var err error
+			manager, err = certs.NewACME(tlsCfg.ACME, g.logService, g.metricsService)
+			if err != nil {
+				return err
+			}
+			// HTTP-01 challenges arrive on the plain listeners
+			handler = manager.HTTPHandler(handler)
+		}
+		if len(tlsCfg.Certificates) > 0 {
+			certificates, err := certs.NewStore(tlsCfg, g.logService)
+			if err != nil {
+				return err
+			}
+			g.certificates = certificates
+			g.health.Register("certificates:expiry", certificates.HealthCheck)
+		}
+		var err error
+		tlsConfig, err = certs.NewTLSConfig(tlsCfg, g.certificates, manager)
+		if err != nil {
+			return err
+		}
+
+		var tlsHandler http.Handler = g.router
+		if tlsCfg.HTTP3.Enabled() {
+			tlsHandler = advertiseHTTP3(g.router, tlsCfg.HTTP3)
+		}
+		g.tlsServer = NewHTTPServer(serverCfg, tlsHandler)
+		g.tlsServer.TLSConfig = tlsConfig
+		// Clients negotiate HTTP/2 through ALPN
+		if err := http2.ConfigureServer(g.tlsServer, &http2.Server{}); err != nil {
+			return err
+		}
 	}
 
-	return g.server.ListenAndServe()
+	// Cleartext HTTP/2, either with prior knowledge or through an Upgrade
+	if serverCfg.H2C {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	g.server.Handler = handler
+
+	// Open every listener before serving so a bad address fails the start
+	plain := append([]config.Listener{{
+		Network:       config.NetworkTCP,
+		Address:       listenAddress(serverCfg, serverCfg.Port),
+		ProxyProtocol: serverCfg.ProxyProtocol.Enabled,
+	}}, serverCfg.Listeners...)
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}
+	for _, listener := range plain {
+		ln, err := listen(serverCfg, listener)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("listening on %s: %w", listener.Address, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	var tlsListener net.Listener
+	if g.tlsServer != nil {
+		address := listenAddress(serverCfg, serverCfg.TLS.Port)
+		ln, err := listen(serverCfg, config.Listener{
+			Network:       config.NetworkTCP,
+			Address:       address,
+			ProxyProtocol: serverCfg.ProxyProtocol.Enabled,
+		})
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("listening on %s: %w", address, err)
+		}
+		tlsListener = ln
+	}
+
+	g.listening.Store(true)
+
+	errs := make(chan error, len(listeners)+2)
+	for _, ln := range listeners {
+		go func() {
+			errs <- g.server.Serve(ln)
+		}()
+	}
+	if tlsListener != nil {
+		go func() {
+			errs <- g.tlsServer.ServeTLS(tlsListener, "", "")
+		}()
+
+		if h3 := serverCfg.TLS.HTTP3; h3.Enabled() {
+			g.quicServer = &http3.Server{
+				Addr:           listenAddress(serverCfg, h3.Port),
+				Handler:        g.router,
+				TLSConfig:      http3.ConfigureTLSConfig(tlsConfig),
+				MaxHeaderBytes: serverCfg.MaxHeaderBytes,
+				IdleTimeout:    serverCfg.IdleTimeout.Or(defaultIdleTimeout),
+			}
+			go func() {
+				errs <- g.quicServer.ListenAndServe()
+			}()
+		}
+	}
+
+	return <-errs
+}
+
+// advertiseHTTP3 tells clients of the TLS listener that the same origin is
+// also served over HTTP/3
+func advertiseHTTP3(next http.Handler, cfg config.HTTP3Config) http.Handler {
+	altSvc := fmt.Sprintf(`h3=":%d"; ma=%d`, cfg.Port, int(cfg.MaxAge.Or(defaultAltSvcMaxAge).Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", altSvc)
+		next.ServeHTTP(w, r)
+	})
 }
 
-// Shutdown gracefully shuts down the server
+// Drain marks the gateway as not ready, so load balancers stop sending new
+// requests while the ones already sent are still served
+func (g *Gateway) Drain() {
+	g.draining.Store(true)
+}
+
+// InFlight returns the number of requests currently being served
+func (g *Gateway) InFlight() int {
+	return g.inFlight.Count()
+}
+
+// Shutdown gracefully shuts down the servers, waiting for in-flight requests
+// until ctx is done. Requests still running then are logged and their
+// connections closed.
 func (g *Gateway) Shutdown(ctx context.Context) error {
-	return g.server.Shutdown(ctx)
+	g.Drain()
+	g.discovery.Stop()
+	if g.certificates != nil {
+		g.certificates.Close()
+	}
+
+	var errs []error
+	if g.tlsServer != nil {
+		errs = append(errs, g.tlsServer.Shutdown(ctx))
+	}
+	if g.quicServer != nil {
+		errs = append(errs, g.quicServer.Shutdown(ctx))
+	}
+	errs = append(errs, g.server.Shutdown(ctx))
+	err := errors.Join(errs...)
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+
+	for _, request := range g.inFlight.Requests() {
+		g.logService.Warnf("Shutdown: cutting off %s %s from %s after %s (request %s)",
+			request.Method, request.Path, request.Client, time.Since(request.Start).Round(time.Millisecond), request.ID)
+	}
+	if g.tlsServer != nil {
+		g.tlsServer.Close()
+	}
+	if g.quicServer != nil {
+		g.quicServer.Close()
+	}
+	g.server.Close()
+	return err
 }
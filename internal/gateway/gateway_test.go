@@ -2,29 +2,34 @@ package gateway
 
 import (
 	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/clientip"
 	"github.com/leo-andrei/api-gateway/internal/logging"
 	"github.com/leo-andrei/api-gateway/internal/metrics"
+	"github.com/leo-andrei/api-gateway/internal/middleware"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func TestGatewayHealthEndpoint(t *testing.T) {
 	// Setup
 	cfg := &config.Config{
-		Server: struct {
-			Port int `yaml:"port"`
-		}{
+		Server: config.ServerConfig{
 			Port: 8080,
 		},
-		Logging: struct {
-			Level  string `yaml:"level"`
-			Format string `yaml:"format"`
-		}{
+		Logging: logging.LoggingConfig{
 			Level:  "info",
 			Format: "json",
 		},
@@ -33,7 +38,7 @@ func TestGatewayHealthEndpoint(t *testing.T) {
 
 	logService := logging.NewLogService(cfg.Logging)
 	metricsService := metrics.NewMetricsService()
-	gw := NewGateway(cfg, logService, metricsService)
+	gw := NewGateway(cfg, logService, metricsService, nil)
 	gw.SetupRoutes()
 
 	// Create a request to the health endpoint
@@ -62,15 +67,10 @@ func TestGatewayHealthEndpoint(t *testing.T) {
 
 func TestNewGateway(t *testing.T) {
 	cfg := &config.Config{
-		Server: struct {
-			Port int `yaml:"port"`
-		}{
+		Server: config.ServerConfig{
 			Port: 8080,
 		},
-		Logging: struct {
-			Level  string `yaml:"level"`
-			Format string `yaml:"format"`
-		}{
+		Logging: logging.LoggingConfig{
 			Level:  "info",
 			Format: "json",
 		},
@@ -79,7 +79,7 @@ func TestNewGateway(t *testing.T) {
 	logService := logging.NewLogService(cfg.Logging)
 	metricsService := metrics.NewMetricsService()
 
-	gw := NewGateway(cfg, logService, metricsService)
+	gw := NewGateway(cfg, logService, metricsService, nil)
 
 	assert.NotNil(t, gw)
 	assert.Equal(t, cfg, gw.config)
@@ -90,15 +90,10 @@ func TestNewGateway(t *testing.T) {
 
 func TestGateway_Shutdown(t *testing.T) {
 	cfg := &config.Config{
-		Server: struct {
-			Port int `yaml:"port"`
-		}{
+		Server: config.ServerConfig{
 			Port: 8080,
 		},
-		Logging: struct {
-			Level  string `yaml:"level"`
-			Format string `yaml:"format"`
-		}{
+		Logging: logging.LoggingConfig{
 			Level:  "info",
 			Format: "json",
 		},
@@ -106,7 +101,7 @@ func TestGateway_Shutdown(t *testing.T) {
 	}
 	logService := logging.NewLogService(cfg.Logging)
 	metricsService := metrics.NewMetricsService()
-	gw := NewGateway(cfg, logService, metricsService)
+	gw := NewGateway(cfg, logService, metricsService, nil)
 
 	gw.server = &http.Server{
 		Addr:    ":8080",
@@ -119,3 +114,276 @@ func TestGateway_Shutdown(t *testing.T) {
 	err := gw.Shutdown(ctx)
 	assert.NoError(t, err)
 }
+
+func TestGateway_ApplyConfigReplacesRoutes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			Port: 8080,
+		},
+		Logging: logging.LoggingConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Routes: []config.Route{},
+	}
+	logService := logging.NewLogService(cfg.Logging)
+	metricsService := metrics.NewMetricsService()
+	gw := NewGateway(cfg, logService, metricsService, nil)
+	gw.SetupRoutes()
+
+	rr := httptest.NewRecorder()
+	gw.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/items", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	updated := cfg.Clone()
+	updated.Upstreams = []config.Upstream{{Name: "items", URL: upstream.URL}}
+	updated.Routes = []config.Route{{Name: "items", Path: "/api/items", Upstream: "items", TargetURL: "/items", Method: "GET"}}
+	gw.ApplyConfig(updated)
+
+	rr = httptest.NewRecorder()
+	gw.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/items", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "upstream", rr.Body.String())
+
+	// The built-in endpoints are unaffected by route changes
+	rr = httptest.NewRecorder()
+	gw.router.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestGateway_ServiceRoutes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	servicesFile := filepath.Join(t.TempDir(), "services.yaml")
+	require.NoError(t, os.WriteFile(servicesFile, []byte("users:\n  - "+upstream.Listener.Addr().String()+"\n"), 0o644))
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			Port: 8080,
+		},
+		Logging: logging.LoggingConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Discovery: config.DiscoveryConfig{
+			File: config.FileDiscoveryConfig{Path: servicesFile, Interval: config.Duration(10 * time.Millisecond)},
+		},
+		Services: []config.Service{
+			{Name: "users", Provider: config.ProviderFile, Scheme: "http"},
+			{Name: "orders", Provider: config.ProviderFile, Scheme: "http"},
+		},
+		Routes: []config.Route{
+			{Name: "users", Path: "/api/users", Service: "users", TargetURL: "/users", Method: "GET"},
+			{Name: "orders", Path: "/api/orders", Service: "orders", TargetURL: "/orders", Method: "GET"},
+		},
+	}
+	logService := logging.NewLogService(cfg.Logging)
+	metricsService := metrics.NewMetricsService()
+	gw := NewGateway(cfg, logService, metricsService, nil)
+	gw.SetupRoutes()
+	defer gw.discovery.Stop()
+
+	require.Eventually(t, func() bool {
+		rr := httptest.NewRecorder()
+		gw.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/users", nil))
+		return rr.Code == http.StatusOK && rr.Body.String() == "/users"
+	}, time.Second, 10*time.Millisecond)
+
+	// Services without endpoints are unavailable rather than failing to connect
+	rr := httptest.NewRecorder()
+	gw.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/orders", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+func TestCreateProxyHandler_ForwardedProto(t *testing.T) {
+	var proto string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto = r.Header.Get("X-Forwarded-Proto")
+	}))
+	defer upstream.Close()
+
+	handler := CreateProxyHandler(config.Route{Path: "/api", TargetURL: upstream.URL, Method: "GET"}, metrics.NewMetricsService(), nil)
+
+	req := httptest.NewRequest("GET", "/api", nil)
+	req.Header.Set("X-Forwarded-Proto", "spoofed")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "http", proto)
+
+	req = httptest.NewRequest("GET", "https://gateway/api", nil)
+	req.TLS = &tls.ConnectionState{}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "https", proto)
+}
+
+func TestCreateProxyHandler_UpstreamProtocol(t *testing.T) {
+	protos := make(chan int, 1)
+	upstream := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protos <- r.ProtoMajor
+	}), &http2.Server{}))
+	defer upstream.Close()
+
+	tests := []struct {
+		protocol string
+		expected int
+	}{
+		{protocol: "", expected: 1},
+		{protocol: config.ProtocolHTTP1, expected: 1},
+		{protocol: config.ProtocolH2C, expected: 2},
+	}
+	for _, tt := range tests {
+		handler := CreateProxyHandler(config.Route{Path: "/api", TargetURL: upstream.URL, Method: "GET", Protocol: tt.protocol}, metrics.NewMetricsService(), nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api", nil))
+		require.Equal(t, http.StatusOK, rr.Code, tt.protocol)
+		assert.Equal(t, tt.expected, <-protos, tt.protocol)
+	}
+}
+
+func TestCreateProxyHandler_RemovesHopHeaders(t *testing.T) {
+	var header http.Header
+	upstream := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.Header().Set("X-Upstream", "yes")
+	}), &http2.Server{}))
+	defer upstream.Close()
+
+	handler := CreateProxyHandler(config.Route{Path: "/api", TargetURL: upstream.URL, Method: "GET", Protocol: config.ProtocolH2C}, metrics.NewMetricsService(), nil)
+	req := httptest.NewRequest("GET", "/api", nil)
+	req.Header.Set("Connection", "keep-alive, X-Hop")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("X-End-To-End", "1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "yes", rr.Header().Get("X-Upstream"))
+	assert.Empty(t, header.Get("Connection"))
+	assert.Empty(t, header.Get("Keep-Alive"))
+	assert.Empty(t, header.Get("X-Hop"))
+	assert.Equal(t, "1", header.Get("X-End-To-End"))
+}
+
+func TestAdvertiseHTTP3(t *testing.T) {
+	handler := advertiseHTTP3(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), config.HTTP3Config{Port: 8443})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "https://gateway/api", nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, `h3=":8443"; ma=86400`, rr.Header().Get("Alt-Svc"))
+
+	handler = advertiseHTTP3(http.NotFoundHandler(), config.HTTP3Config{Port: 443, MaxAge: config.Duration(time.Hour)})
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "https://gateway/api", nil))
+	assert.Equal(t, `h3=":443"; ma=3600`, rr.Header().Get("Alt-Svc"))
+}
+
+func TestCreateProxyHandler_ForwardedHeaders(t *testing.T) {
+	var header http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	_, trusted, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	handler := middleware.ClientIPMiddleware(
+		CreateProxyHandler(config.Route{Path: "/api", TargetURL: upstream.URL, Method: "GET"}, metrics.NewMetricsService(), nil),
+		clientip.NewResolver([]*net.IPNet{trusted}),
+	)
+
+	// Values sent by an untrusted client are replaced
+	req := httptest.NewRequest("GET", "http://api.example.com:8080/api", nil)
+	req.RemoteAddr = "203.0.113.7:4711"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("Forwarded", "for=198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "203.0.113.7", header.Get("X-Forwarded-For"))
+	assert.Equal(t, "api.example.com:8080", header.Get("X-Forwarded-Host"))
+	assert.Equal(t, `for=203.0.113.7;host="api.example.com:8080";proto=http`, header.Get("Forwarded"))
+
+	// A trusted proxy's chain is extended with this hop
+	req = httptest.NewRequest("GET", "http://api.example.com/api", nil)
+	req.RemoteAddr = "10.0.0.1:4711"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("Forwarded", "for=198.51.100.1;proto=https")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "198.51.100.1, 10.0.0.1", header.Get("X-Forwarded-For"))
+	assert.Equal(t, "https", header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "for=198.51.100.1;proto=https, for=10.0.0.1;host=api.example.com;proto=http", header.Get("Forwarded"))
+
+	// IPv6 peers are bracketed and quoted
+	req = httptest.NewRequest("GET", "http://api.example.com/api", nil)
+	req.RemoteAddr = "[2001:db8::1]:4711"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "2001:db8::1", header.Get("X-Forwarded-For"))
+	assert.Equal(t, `for="[2001:db8::1]";host=api.example.com;proto=http`, header.Get("Forwarded"))
+}
+
+func TestGatewayMetricsEndpoint(t *testing.T) {
+	cfg := &config.Config{Server: config.ServerConfig{Port: 8080}}
+	metricsService := metrics.NewMetricsService(metrics.WithNamespace("edge"))
+	gw := NewGateway(cfg, logging.Discard(), metricsService, nil)
+	gw.SetupRoutes()
+
+	metricsService.IncrementRequestCount("GET", "users", "/users", "200")
+
+	rr := httptest.NewRecorder()
+	gw.router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `edge_requests_total{method="GET",path="/users",route="users",status="200"} 1`)
+}
+
+func TestGatewayMetricsUseRouteTemplates(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Server:  config.ServerConfig{Port: 8080},
+		Metrics: metrics.MetricsConfig{UnmatchedPath: "no_route"},
+		Routes: []config.Route{
+			{Name: "user", Path: "/users/{id}", TargetURL: upstream.URL + "/users", Method: "GET"},
+		},
+	}
+	metricsService := metrics.NewMetricsService()
+	gw := NewGateway(cfg, logging.Discard(), metricsService, nil)
+	gw.SetupRoutes()
+
+	for _, path := range []string{"/users/1", "/users/2", "/missing"} {
+		gw.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	counts := metricsService.RequestCount
+	assert.Equal(t, 2, testutil.CollectAndCount(counts))
+	assert.Equal(t, 2.0, testutil.ToFloat64(counts.WithLabelValues("GET", "user", "/users/{id}", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(counts.WithLabelValues("GET", "", "no_route", "404")))
+}
+
+func TestGatewayForwardsRequestID(t *testing.T) {
+	var upstreamID string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamID = r.Header.Get("X-Request-ID")
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{Routes: []config.Route{{Path: "/api", TargetURL: upstream.URL, Method: "GET"}}}
+	gw := NewGateway(cfg, logging.Discard(), metrics.NewMetricsService(), nil)
+	gw.SetupRoutes()
+
+	rr := httptest.NewRecorder()
+	gw.router.ServeHTTP(rr, httptest.NewRequest("GET", "/api", nil))
+
+	assert.NotEmpty(t, upstreamID)
+	assert.Equal(t, upstreamID, rr.Header().Get("X-Request-ID"))
+}
@@ -0,0 +1,160 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/leo-andrei/api-gateway/internal/health"
+)
+
+const healthDescription = "API Gateway"
+
+// healthRoutes registers the liveness, readiness and health endpoints
+func (g *Gateway) healthRoutes(router *mux.Router) {
+	router.Handle("/livez", g.observe(http.HandlerFunc(g.livez))).Methods("GET")
+	router.Handle("/readyz", g.observe(http.HandlerFunc(g.readyz))).Methods("GET")
+	if g.config.Health.Details {
+		router.Handle("/health/details", g.observe(g.health.Handler(healthDescription))).Methods("GET")
+	}
+
+	// Kept for existing probes
+	router.Handle("/health", g.observe(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "OK")
+	}))).Methods("GET")
+	router.Handle("/ready", g.observe(http.HandlerFunc(g.readyz))).Methods("GET")
+}
+
+// HealthHandler serves only the liveness, readiness and health endpoints, for
+// exposing them on the admin port
+func (g *Gateway) HealthHandler() http.Handler {
+	router := mux.NewRouter()
+	g.healthRoutes(router)
+	return router
+}
+
+// livez reports that the process is running
+func (g *Gateway) livez(w http.ResponseWriter, r *http.Request) {
+	health.Write(w, health.Response{Status: health.StatusPass, Description: healthDescription})
+}
+
+// readyz reports whether the gateway should receive traffic: its
+// configuration is loaded, its listeners are up and it is not draining
+func (g *Gateway) readyz(w http.ResponseWriter, r *http.Request) {
+	response := health.Response{Status: health.StatusFail, Description: healthDescription}
+	switch {
+	case g.active.Load() == nil:
+		response.Output = "configuration not loaded"
+	case !g.listening.Load():
+		response.Output = "listeners not started"
+	case g.draining.Load():
+		response.Output = "draining"
+	default:
+		response.Status = health.StatusPass
+	}
+	health.Write(w, response)
+}
+
+// checkUpstreams reports whether a TCP connection can be opened to every
+// configured upstream and to the targets of routes that do not reference one.
+// Route targets are named by their address and only checked once per address.
+// Routes to discovered services are left to the discovery check.
+func (g *Gateway) checkUpstreams(ctx context.Context) []health.Result {
+	cfg := g.active.Load()
+	if cfg == nil {
+		return nil
+	}
+
+	type target struct{ name, url string }
+	targets := make([]target, 0, len(cfg.Upstreams)+len(cfg.Routes))
+	seen := make(map[string]bool)
+	for _, upstream := range cfg.Upstreams {
+		targets = append(targets, target{upstream.Name, upstream.URL})
+		if address, err := dialAddress(upstream.URL); err == nil {
+			seen[address] = true
+		}
+	}
+	for _, route := range cfg.Routes {
+		if route.Upstream != "" || route.Service != "" || route.TargetURL == "" {
+			continue
+		}
+		address, err := dialAddress(route.TargetURL)
+		if err != nil {
+			address = route.TargetURL
+		}
+		if seen[address] {
+			continue
+		}
+		seen[address] = true
+		targets = append(targets, target{address, route.TargetURL})
+	}
+
+	results := make([]health.Result, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = checkReachable(ctx, target.name, target.url)
+		}()
+	}
+	wg.Wait()
+
+	health.SortResults(results)
+	return results
+}
+
+// dialAddress returns the host:port a URL connects to
+func dialAddress(rawURL string) (string, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if target.Hostname() == "" {
+		return "", fmt.Errorf("no host in %q", rawURL)
+	}
+	port := target.Port()
+	if port == "" {
+		port = "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(target.Hostname(), port), nil
+}
+
+func checkReachable(ctx context.Context, name, rawURL string) health.Result {
+	result := health.Result{
+		ComponentID:   name,
+		ComponentType: "component",
+		ObservedUnit:  "ms",
+		Status:        health.StatusPass,
+		Time:          health.Now(),
+	}
+
+	address, err := dialAddress(rawURL)
+	if err != nil {
+		result.Status = health.StatusFail
+		result.Output = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		result.Status = health.StatusFail
+		result.Output = err.Error()
+		return result
+	}
+	conn.Close()
+	result.ObservedValue = time.Since(start).Milliseconds()
+	return result
+}
@@ -0,0 +1,160 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/health"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+	"github.com/leo-andrei/api-gateway/internal/metrics"
+)
+
+func getHealth(t *testing.T, handler http.Handler, path string) (int, health.Response) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	var response health.Response
+	if w.Code != http.StatusNotFound {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	}
+	return w.Code, response
+}
+
+func TestGateway_Readiness(t *testing.T) {
+	gw := NewGateway(&config.Config{}, logging.Discard(), metrics.NewMetricsService(), nil)
+	gw.SetupRoutes()
+
+	code, response := getHealth(t, gw.router, "/livez")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, health.StatusPass, response.Status)
+
+	code, response = getHealth(t, gw.router, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "listeners not started", response.Output)
+
+	gw.listening.Store(true)
+	code, _ = getHealth(t, gw.router, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	gw.Drain()
+	code, response = getHealth(t, gw.router, "/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "draining", response.Output)
+
+	// Liveness is unaffected by draining
+	code, _ = getHealth(t, gw.router, "/livez")
+	assert.Equal(t, http.StatusOK, code)
+
+	// Details are off by default
+	code, _ = getHealth(t, gw.router, "/health/details")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestGateway_HealthDetails(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := ln.Addr().String()
+	ln.Close()
+
+	cfg := &config.Config{
+		Health: config.HealthConfig{Details: true},
+		Upstreams: []config.Upstream{
+			{Name: "up", URL: upstream.URL},
+			{Name: "down", URL: "http://" + closed},
+		},
+	}
+	gw := NewGateway(cfg, logging.Discard(), metrics.NewMetricsService(), nil)
+	gw.SetupRoutes()
+
+	code, response := getHealth(t, gw.router, "/health/details")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, health.StatusFail, response.Status)
+
+	results := response.Checks["upstreams:reachability"]
+	require.Len(t, results, 2)
+	assert.Equal(t, "down", results[0].ComponentID)
+	assert.Equal(t, health.StatusFail, results[0].Status)
+	assert.NotEmpty(t, results[0].Output)
+	assert.Equal(t, "up", results[1].ComponentID)
+	assert.Equal(t, health.StatusPass, results[1].Status)
+}
+
+func TestGateway_HealthDetailsRouteTargets(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+	named := httptest.NewServer(http.NotFoundHandler())
+	defer named.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := ln.Addr().String()
+	ln.Close()
+
+	cfg := &config.Config{
+		Health:    config.HealthConfig{Details: true},
+		Upstreams: []config.Upstream{{Name: "named", URL: named.URL}},
+		Routes: []config.Route{
+			{Name: "users", Path: "/users", TargetURL: upstream.URL + "/users"},
+			{Name: "orders", Path: "/orders", TargetURL: upstream.URL + "/orders"},
+			{Name: "legacy", Path: "/legacy", TargetURL: "http://" + closed + "/legacy"},
+			{Name: "same-as-named", Path: "/named", TargetURL: named.URL + "/named"},
+			{Name: "via-upstream", Path: "/via", Upstream: "named", TargetURL: "/via"},
+			{Name: "discovered", Path: "/discovered", Service: "users", TargetURL: "/users"},
+		},
+	}
+	gw := NewGateway(cfg, logging.Discard(), metrics.NewMetricsService(), nil)
+	gw.SetupRoutes()
+
+	code, response := getHealth(t, gw.router, "/health/details")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	// Each address is checked once, under the upstream name when it has one.
+	// The service route is left to the discovery check.
+	results := response.Checks["upstreams:reachability"]
+	require.Len(t, results, 3)
+	statuses := map[string]string{}
+	for _, result := range results {
+		statuses[result.ComponentID] = result.Status
+	}
+	assert.Equal(t, map[string]string{
+		"named": health.StatusPass,
+		strings.TrimPrefix(upstream.URL, "http://"): health.StatusPass,
+		closed: health.StatusFail,
+	}, statuses)
+}
+
+func TestGateway_HealthOnAdminPort(t *testing.T) {
+	cfg := &config.Config{Health: config.HealthConfig{AdminPort: true}}
+	gw := NewGateway(cfg, logging.Discard(), metrics.NewMetricsService(), nil)
+	gw.SetupRoutes()
+
+	code, _ := getHealth(t, gw.router, "/livez")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = getHealth(t, gw.HealthHandler(), "/livez")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestDialAddress(t *testing.T) {
+	for rawURL, want := range map[string]string{
+		"http://users:8081/users": "users:8081",
+		"http://users/users":      "users:80",
+		"https://users":           "users:443",
+		"http://[::1]:8080":       "[::1]:8080",
+	} {
+		address, err := dialAddress(rawURL)
+		require.NoError(t, err, rawURL)
+		assert.Equal(t, want, address)
+	}
+
+	_, err := dialAddress("/users")
+	assert.EqualError(t, err, `no host in "/users"`)
+}
@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/leo-andrei/api-gateway/internal/clientip"
+	"github.com/leo-andrei/api-gateway/internal/metrics"
+	"github.com/leo-andrei/api-gateway/internal/requestid"
+)
+
+// inFlightRequest describes a request that is being served
+type inFlightRequest struct {
+	ID     string
+	Method string
+	Path   string
+	Client string
+	Start  time.Time
+}
+
+// inFlightTracker keeps the requests being served, so the ones still running
+// when shutdown times out can be reported
+type inFlightTracker struct {
+	mu             sync.Mutex
+	next           uint64
+	requests       map[uint64]inFlightRequest
+	metricsService metrics.Metrics
+}
+
+func newInFlightTracker(metrics metrics.Metrics) *inFlightTracker {
+	return &inFlightTracker{
+		requests:       make(map[uint64]inFlightRequest),
+		metricsService: metrics,
+	}
+}
+
+// Middleware tracks every request passing through next
+func (t *inFlightTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := t.add(inFlightRequest{
+			ID:     requestid.FromRequest(r),
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Client: clientip.FromRequest(r).IP,
+			Start:  time.Now(),
+		})
+		defer t.remove(id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (t *inFlightTracker) add(request inFlightRequest) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	t.requests[t.next] = request
+	t.metricsService.SetRequestsInFlight(float64(len(t.requests)))
+	return t.next
+}
+
+func (t *inFlightTracker) remove(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.requests, id)
+	t.metricsService.SetRequestsInFlight(float64(len(t.requests)))
+}
+
+// Count returns the number of requests being served
+func (t *inFlightTracker) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.requests)
+}
+
+// Requests returns the requests being served, oldest first
+func (t *inFlightTracker) Requests() []inFlightRequest {
+	t.mu.Lock()
+	requests := make([]inFlightRequest, 0, len(t.requests))
+	for _, request := range t.requests {
+		requests = append(requests, request)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Start.Before(requests[j].Start)
+	})
+	return requests
+}
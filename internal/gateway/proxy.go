@@ -1,22 +1,125 @@
 package gateway
 
 import (
+	"context"
+	"crypto/tls"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
 
 	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/clientip"
+	"github.com/leo-andrei/api-gateway/internal/discovery"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+	"github.com/leo-andrei/api-gateway/internal/metrics"
+	"github.com/leo-andrei/api-gateway/internal/tracing"
 )
 
+// transports are shared by all routes so connections to an upstream are
+// reused, and multiplexed for HTTP/2
+var transports = map[string]http.RoundTripper{
+	// HTTP/2 when the upstream offers it over TLS, HTTP/1.1 otherwise
+	"":                   http.DefaultTransport,
+	config.ProtocolHTTP1: newHTTP1Transport(),
+	config.ProtocolH2:    &http2.Transport{},
+	config.ProtocolH2C: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	},
+}
+
+func newHTTP1Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = false
+	// A non-nil empty map disables the HTTP/2 upgrade during ALPN
+	transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	return transport
+}
+
+// hopHeaders are meaningful for a single connection only and must not be
+// forwarded; HTTP/2 rejects most of them outright
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders deletes the hop-by-hop headers, including the ones named
+// in the Connection header
+func removeHopHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+// targetFunc returns the URL a request is forwarded to. ok is false when no
+// target is currently available.
+type targetFunc func() (target string, ok bool)
+
 // CreateProxyHandler creates a handler function for a given route
-func CreateProxyHandler(route config.Route) http.HandlerFunc {
+func CreateProxyHandler(route config.Route, metrics metrics.Metrics, tracer *tracing.Tracing) http.HandlerFunc {
+	return createProxyHandler(route, metrics, tracer, func() (string, bool) {
+		return route.TargetURL, true
+	})
+}
+
+// CreateDiscoveryProxyHandler creates a handler function for a route that
+// forwards to the endpoints of a discovered service in round-robin order. The
+// route's targetUrl is used as the path on the selected endpoint.
+func CreateDiscoveryProxyHandler(route config.Route, service config.Service, registry *discovery.Registry, metrics metrics.Metrics, tracer *tracing.Tracing) http.HandlerFunc {
+	path := "/" + strings.TrimPrefix(route.TargetURL, "/")
+	return createProxyHandler(route, metrics, tracer, func() (string, bool) {
+		endpoint, ok := registry.Next(service.Name)
+		if !ok {
+			return "", false
+		}
+		return service.Scheme + "://" + endpoint.Address() + path, true
+	})
+}
+
+func createProxyHandler(route config.Route, metrics metrics.Metrics, tracer *tracing.Tracing, target targetFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context())
+		targetURL, ok := target()
+		if !ok {
+			logger.Warnf("No upstream available for route %s", route.Path)
+			http.Error(w, "No upstream available", http.StatusServiceUnavailable)
+			return
+		}
+
 		// Create a new request to the target URL
-		client := &http.Client{}
-		req, err := http.NewRequest(route.Method, route.TargetURL, r.Body)
+		client := &http.Client{Transport: transports[route.Protocol]}
+		req, err := http.NewRequest(route.Method, targetURL, r.Body)
 		if err != nil {
+			logger.Errorf("Error creating request to %s: %v", targetURL, err)
 			http.Error(w, "Error creating request", http.StatusInternalServerError)
 			return
 		}
+		ctx, span := startUpstreamSpan(r.Context(), tracer, req)
+		defer span.End()
+		trace := newUpstreamTrace(metrics, req.URL.Host)
+		req = req.WithContext(trace.withContext(ctx))
 
 		// Copy headers from the original request
 		for name, values := range r.Header {
@@ -24,19 +127,26 @@ func CreateProxyHandler(route config.Route) http.HandlerFunc {
 				req.Header.Add(name, value)
 			}
 		}
-
-		// Add X-Forwarded headers
-		req.Header.Add("X-Forwarded-For", r.RemoteAddr)
-		req.Header.Add("X-Forwarded-Host", r.Host)
-		req.Header.Add("X-Forwarded-Proto", "http") // or "https" if using TLS
+		removeHopHeaders(req.Header)
+		setForwardedHeaders(req, r)
+		tracer.Inject(ctx, req.Header)
 
 		// Make the request to the target URL
+		start := time.Now()
 		resp, err := client.Do(req)
+		logging.AccessFromRequest(r).SetUpstream(req.URL.Host, time.Since(start))
 		if err != nil {
+			category := upstreamErrorCategory(err)
+			metrics.IncrementUpstreamErrors(req.URL.Host, category)
+			endUpstreamSpanWithError(span, err, category)
+			logUpstreamError(logger, req, err, category)
 			http.Error(w, "Error forwarding request", http.StatusBadGateway)
 			return
 		}
+		metrics.IncrementUpstreamResponses(req.URL.Host, strconv.Itoa(resp.StatusCode))
+		setUpstreamSpanStatus(span, resp.StatusCode)
 		defer resp.Body.Close()
+		removeHopHeaders(resp.Header)
 
 		// Copy response headers to the client response
 		for name, values := range resp.Header {
@@ -49,6 +159,83 @@ func CreateProxyHandler(route config.Route) http.HandlerFunc {
 		w.WriteHeader(resp.StatusCode)
 
 		// Copy the response body to the client
-		io.Copy(w, resp.Body)
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			logger.With(logging.Fields{"upstream": req.URL.Host}).
+				Warnf("Error copying response from %s: %v", targetURL, err)
+		}
+	}
+}
+
+// setForwardedHeaders describes the client to the upstream in the
+// X-Forwarded-* and RFC 7239 Forwarded headers. Headers received from a
+// trusted proxy are extended with this hop; anything else the client sent is
+// dropped so it cannot spoof its address.
+func setForwardedHeaders(out, in *http.Request) {
+	client := clientip.FromRequest(in)
+	proto := requestScheme(in)
+	if !client.TrustedPeer {
+		for _, name := range []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "Forwarded"} {
+			out.Header.Del(name)
+		}
+	}
+
+	// Peers without an IP, such as Unix socket clients, are not listed
+	forwardedFor := "unknown"
+	if net.ParseIP(client.Peer) != nil {
+		forwardedFor = client.Peer
+		hops := out.Header.Values("X-Forwarded-For")
+		out.Header.Set("X-Forwarded-For", strings.Join(append(hops, client.Peer), ", "))
+	}
+	if out.Header.Get("X-Forwarded-Host") == "" {
+		out.Header.Set("X-Forwarded-Host", in.Host)
+	}
+	if out.Header.Get("X-Forwarded-Proto") == "" {
+		out.Header.Set("X-Forwarded-Proto", proto)
+	}
+
+	element := forwardedElement(forwardedFor, in.Host, proto)
+	elements := out.Header.Values("Forwarded")
+	out.Header.Set("Forwarded", strings.Join(append(elements, element), ", "))
+}
+
+// forwardedElement formats one element of the Forwarded header
+func forwardedElement(forwardedFor, host, proto string) string {
+	if strings.Contains(forwardedFor, ":") {
+		// IPv6 addresses are bracketed and, containing colons, quoted
+		forwardedFor = "[" + forwardedFor + "]"
+	}
+	element := "for=" + quoteForwarded(forwardedFor)
+	if host != "" {
+		element += ";host=" + quoteForwarded(host)
+	}
+	return element + ";proto=" + proto
+}
+
+// quoteForwarded returns value as a token when possible and as a quoted
+// string otherwise
+func quoteForwarded(value string) string {
+	isToken := value != ""
+	for _, c := range value {
+		if !isTokenChar(c) {
+			isToken = false
+			break
+		}
+	}
+	if isToken {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func isTokenChar(c rune) bool {
+	return c < 0x80 && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.ContainsRune("!#$%&'*+-.^_`|~", c))
+}
+
+// requestScheme returns the scheme the client used to reach the gateway
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
 	}
+	return "http"
 }
@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+	"golang.org/x/net/netutil"
+
+	"github.com/leo-andrei/api-gateway/config"
+)
+
+const (
+	defaultReadTimeout       = 30 * time.Second
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultMaxConnections    = 10000
+)
+
+// NewHTTPServer creates a server with the timeouts and header limit of cfg,
+// falling back to defaults that keep slow or oversized clients from holding
+// connections open. The admin API uses it too.
+func NewHTTPServer(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout.Or(defaultReadTimeout),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout.Or(defaultReadHeaderTimeout),
+		WriteTimeout:      cfg.WriteTimeout.Or(defaultWriteTimeout),
+		IdleTimeout:       cfg.IdleTimeout.Or(defaultIdleTimeout),
+		// Zero means http.DefaultMaxHeaderBytes
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+	}
+}
+
+// listenAddress returns the TCP address of port on the configured bind address
+func listenAddress(cfg config.ServerConfig, port int) string {
+	return net.JoinHostPort(cfg.Address, strconv.Itoa(port))
+}
+
+// listen opens a listener that accepts at most cfg.MaxConnections concurrent
+// connections
+func listen(cfg config.ServerConfig, listener config.Listener) (net.Listener, error) {
+	if listener.Network == config.NetworkUnix {
+		if err := removeStaleSocket(listener.Address); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen(listener.Network, listener.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	maxConnections := cfg.MaxConnections
+	if maxConnections == 0 {
+		maxConnections = defaultMaxConnections
+	}
+	ln = netutil.LimitListener(ln, maxConnections)
+
+	if listener.ProxyProtocol {
+		policy, err := proxyProtocolPolicy(cfg.ProxyProtocol.TrustedCIDRs)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		ln = &proxyproto.Listener{
+			Listener:          ln,
+			ConnPolicy:        policy,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout.Or(defaultReadHeaderTimeout),
+		}
+	}
+	return ln, nil
+}
+
+// proxyProtocolPolicy uses the PROXY header of connections from trusted
+// sources, which then become the request's RemoteAddr, and rejects
+// connections from anywhere else that send one. Peers on Unix sockets are
+// local and always trusted.
+func proxyProtocolPolicy(trustedCIDRs []string) (proxyproto.ConnPolicyFunc, error) {
+	trusted, err := config.ParseCIDRs(trustedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(opts proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+		switch addr := opts.Upstream.(type) {
+		case *net.UnixAddr:
+			return proxyproto.USE, nil
+		case *net.TCPAddr:
+			for _, ipNet := range trusted {
+				if ipNet.Contains(addr.IP) {
+					return proxyproto.USE, nil
+				}
+			}
+		}
+		return proxyproto.REJECT, nil
+	}, nil
+}
+
+// removeStaleSocket deletes a Unix socket left behind by a process that did
+// not shut down cleanly, refusing to touch one that still accepts connections
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		// Missing files are fine, anything else is left for Listen to report
+		return nil
+	}
+	if conn, err := net.DialTimeout(config.NetworkUnix, path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,312 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+	"github.com/leo-andrei/api-gateway/internal/metrics"
+)
+
+// recordingLogger keeps the messages logged with Infof and Warnf
+type recordingLogger struct {
+	logging.Logger
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.Infof(format, args...)
+}
+
+func (l *recordingLogger) Messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.messages...)
+}
+
+// inFlightMetrics records the in-flight gauge, the only metric reported by
+// the gateway's own endpoints
+type inFlightMetrics struct {
+	metrics.Metrics
+	count atomic.Int64
+}
+
+func (m *inFlightMetrics) SetRequestsInFlight(count float64) {
+	m.count.Store(int64(count))
+}
+
+// unixClient returns a client whose connections go to the Unix socket at path
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestNewHTTPServer_Defaults(t *testing.T) {
+	server := NewHTTPServer(config.ServerConfig{}, nil)
+	assert.Equal(t, defaultReadTimeout, server.ReadTimeout)
+	assert.Equal(t, defaultReadHeaderTimeout, server.ReadHeaderTimeout)
+	assert.Equal(t, defaultWriteTimeout, server.WriteTimeout)
+	assert.Equal(t, defaultIdleTimeout, server.IdleTimeout)
+
+	server = NewHTTPServer(config.ServerConfig{
+		ReadHeaderTimeout: config.Duration(2 * time.Second),
+		MaxHeaderBytes:    4096,
+	}, nil)
+	assert.Equal(t, 2*time.Second, server.ReadHeaderTimeout)
+	assert.Equal(t, 4096, server.MaxHeaderBytes)
+}
+
+func TestListen_LimitsConnections(t *testing.T) {
+	ln, err := listen(config.ServerConfig{MaxConnections: 1}, config.Listener{Network: config.NetworkTCP, Address: "127.0.0.1:0"})
+	require.NoError(t, err)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	conn := <-accepted
+
+	second, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+	select {
+	case <-accepted:
+		t.Fatal("second connection accepted while the first is open")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	conn.Close()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("second connection not accepted after the first closed")
+	}
+}
+
+func TestListen_RemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.sock")
+
+	// Leave a socket file behind, as a killed process would
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+
+	ln, err := listen(config.ServerConfig{}, config.Listener{Network: config.NetworkUnix, Address: path})
+	require.NoError(t, err)
+	defer ln.Close()
+
+	// A socket that still accepts connections is left alone
+	_, err = listen(config.ServerConfig{}, config.Listener{Network: config.NetworkUnix, Address: path})
+	assert.ErrorContains(t, err, "in use")
+}
+
+func TestGateway_RunServesUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.sock")
+	cfg := &config.Config{Server: config.ServerConfig{
+		Address:   "127.0.0.1",
+		Listeners: []config.Listener{{Network: config.NetworkUnix, Address: path}},
+	}}
+	gw := NewGateway(cfg, logging.Discard(), nil, nil)
+	gw.router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK")
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- gw.Run()
+	}()
+
+	client := unixClient(path)
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		resp, err = client.Get("http://gateway/health")
+		return err == nil
+	}, 5*time.Second, 20*time.Millisecond)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "OK", string(body))
+
+	require.NoError(t, gw.Shutdown(context.Background()))
+	assert.ErrorIs(t, <-done, http.ErrServerClosed)
+}
+
+// serveRemoteAddr serves a handler on ln that responds with the request's
+// RemoteAddr
+func serveRemoteAddr(t *testing.T, ln net.Listener) {
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	})}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+}
+
+// requestWithHeader sends a request on a new connection, preceded by the
+// given PROXY protocol header when it is not nil, and returns the response
+// status and body
+func requestWithHeader(t *testing.T, address string, header *proxyproto.Header) (int, string) {
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if header != nil {
+		_, err = header.WriteTo(conn)
+		require.NoError(t, err)
+	}
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: gateway\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestListen_ProxyProtocol(t *testing.T) {
+	cfg := config.ServerConfig{ProxyProtocol: config.ProxyProtocolConfig{TrustedCIDRs: []string{"127.0.0.0/8"}}}
+	ln, err := listen(cfg, config.Listener{Network: config.NetworkTCP, Address: "127.0.0.1:0", ProxyProtocol: true})
+	require.NoError(t, err)
+	serveRemoteAddr(t, ln)
+
+	for _, version := range []byte{1, 2} {
+		header := &proxyproto.Header{
+			Version:           version,
+			Command:           proxyproto.PROXY,
+			TransportProtocol: proxyproto.TCPv4,
+			SourceAddr:        &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 54321},
+			DestinationAddr:   ln.Addr(),
+		}
+		status, remoteAddr := requestWithHeader(t, ln.Addr().String(), header)
+		require.Equal(t, http.StatusOK, status, "version %d", version)
+		assert.Equal(t, "203.0.113.7:54321", remoteAddr, "version %d", version)
+	}
+
+	// The header is optional, direct connections keep their own address
+	status, remoteAddr := requestWithHeader(t, ln.Addr().String(), nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, remoteAddr, "127.0.0.1:")
+}
+
+func TestListen_ProxyProtocolUntrustedSource(t *testing.T) {
+	cfg := config.ServerConfig{ProxyProtocol: config.ProxyProtocolConfig{TrustedCIDRs: []string{"10.0.0.0/8"}}}
+	ln, err := listen(cfg, config.Listener{Network: config.NetworkTCP, Address: "127.0.0.1:0", ProxyProtocol: true})
+	require.NoError(t, err)
+	serveRemoteAddr(t, ln)
+
+	header := &proxyproto.Header{
+		Version:           1,
+		Command:           proxyproto.PROXY,
+		TransportProtocol: proxyproto.TCPv4,
+		SourceAddr:        &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 54321},
+		DestinationAddr:   ln.Addr(),
+	}
+	// The server cannot read the request and answers 400 without serving it
+	status, _ := requestWithHeader(t, ln.Addr().String(), header)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, remoteAddr := requestWithHeader(t, ln.Addr().String(), nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, remoteAddr, "127.0.0.1:")
+}
+
+func TestGateway_DrainAndShutdownCutsOffRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.sock")
+	cfg := &config.Config{Server: config.ServerConfig{
+		Address:   "127.0.0.1",
+		Listeners: []config.Listener{{Network: config.NetworkUnix, Address: path}},
+	}}
+	logger := &recordingLogger{Logger: logging.Discard()}
+	inFlight := &inFlightMetrics{Metrics: metrics.NewMetricsService()}
+	gw := NewGateway(cfg, logger, inFlight, nil)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	gw.router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	gw.SetupRoutes()
+	go gw.Run()
+
+	client := unixClient(path)
+	require.Eventually(t, func() bool {
+		resp, err := client.Get("http://gateway/ready")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 20*time.Millisecond)
+
+	go client.Get("http://gateway/slow")
+	<-started
+	assert.Equal(t, 1, gw.InFlight())
+	assert.Equal(t, int64(1), inFlight.count.Load())
+
+	// Readiness fails as soon as draining starts, other requests are still served
+	gw.Drain()
+	resp, err := client.Get("http://gateway/ready")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = gw.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	var cutOff []string
+	for _, message := range logger.Messages() {
+		if strings.Contains(message, "cutting off") {
+			cutOff = append(cutOff, message)
+		}
+	}
+	require.Len(t, cutOff, 1)
+	assert.Contains(t, cutOff[0], "cutting off GET /slow from ")
+}
@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+	"github.com/leo-andrei/api-gateway/internal/metrics"
+	"github.com/leo-andrei/api-gateway/internal/tracing"
+)
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestGateway_TracesRequests(t *testing.T) {
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracer, err := tracing.New(context.Background(), tracing.TracingConfig{Enabled: true}, tracing.WithExporter(exporter))
+	require.NoError(t, err)
+	defer tracer.Shutdown(context.Background())
+
+	cfg := &config.Config{Routes: []config.Route{
+		{Name: "user", Path: "/users/{id}", TargetURL: upstream.URL + "/users", Method: "GET"},
+	}}
+	gw := NewGateway(cfg, logging.Discard(), metrics.NewMetricsService(), tracer)
+	gw.SetupRoutes()
+
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	gw.router.ServeHTTP(httptest.NewRecorder(), req)
+	require.NoError(t, tracer.ForceFlush(context.Background()))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	client, server := spans[0], spans[1]
+
+	assert.Equal(t, "GET /users/{id}", server.Name)
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	assert.Equal(t, "/users/{id}", spanAttribute(server, "http.route").AsString())
+	assert.Equal(t, int64(503), spanAttribute(server, "http.response.status_code").AsInt64())
+	assert.Equal(t, codes.Error, server.Status.Code)
+
+	assert.Equal(t, "GET", client.Name)
+	assert.Equal(t, trace.SpanKindClient, client.SpanKind)
+	assert.Equal(t, server.SpanContext.SpanID(), client.Parent.SpanID())
+	assert.Equal(t, upstream.URL+"/users", spanAttribute(client, "url.full").AsString())
+
+	// The upstream continues the trace from the client span
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+client.SpanContext.SpanID().String()+"-01", upstreamTraceparent)
+}
+
+func TestCreateProxyHandler_TracesUpstreamErrors(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer, err := tracing.New(context.Background(), tracing.TracingConfig{Enabled: true}, tracing.WithExporter(exporter))
+	require.NoError(t, err)
+	defer tracer.Shutdown(context.Background())
+
+	handler := CreateProxyHandler(config.Route{Path: "/api", TargetURL: "http://upstream.invalid", Method: "GET"}, metrics.NewMetricsService(), tracer)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api", nil))
+	require.NoError(t, tracer.ForceFlush(context.Background()))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, upstreamErrorDNS, spanAttribute(spans[0], "error.type").AsString())
+	assert.NotEmpty(t, spans[0].Events)
+}
@@ -0,0 +1,167 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/leo-andrei/api-gateway/internal/logging"
+	"github.com/leo-andrei/api-gateway/internal/metrics"
+	"github.com/leo-andrei/api-gateway/internal/tracing"
+)
+
+// Categories of upstream errors
+const (
+	upstreamErrorDNS      = "dns"
+	upstreamErrorRefused  = "refused"
+	upstreamErrorTimeout  = "timeout"
+	upstreamErrorReset    = "reset"
+	upstreamErrorTLS      = "tls"
+	upstreamErrorCanceled = "canceled"
+	upstreamErrorOther    = "other"
+)
+
+// upstreamTrace measures the connection setup and latency of one request to
+// an upstream
+type upstreamTrace struct {
+	metrics  metrics.Metrics
+	upstream string
+	start    time.Time
+
+	// Connects may race when the upstream resolves to several addresses
+	mu           sync.Mutex
+	connectStart map[string]time.Time
+	tlsStart     time.Time
+}
+
+func newUpstreamTrace(metrics metrics.Metrics, upstream string) *upstreamTrace {
+	return &upstreamTrace{
+		metrics:      metrics,
+		upstream:     upstream,
+		start:        time.Now(),
+		connectStart: make(map[string]time.Time),
+	}
+}
+
+// withContext returns ctx carrying a client trace that reports to the
+// metrics service
+func (t *upstreamTrace) withContext(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.metrics.IncrementUpstreamConnections(t.upstream, info.Reused)
+		},
+		ConnectStart: func(network, addr string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.connectStart[addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mu.Lock()
+			start, ok := t.connectStart[addr]
+			t.mu.Unlock()
+			if ok && err == nil {
+				t.metrics.ObserveUpstreamConnect(t.upstream, time.Since(start).Seconds())
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			t.mu.Lock()
+			start := t.tlsStart
+			t.mu.Unlock()
+			if !start.IsZero() && err == nil {
+				t.metrics.ObserveUpstreamTLSHandshake(t.upstream, time.Since(start).Seconds())
+			}
+		},
+		GotFirstResponseByte: func() {
+			t.metrics.ObserveUpstreamTimeToFirstByte(t.upstream, time.Since(t.start).Seconds())
+		},
+	})
+}
+
+// upstreamErrorCategory classifies the error of a request that got no
+// response from the upstream
+func upstreamErrorCategory(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var recordErr tls.RecordHeaderError
+	switch {
+	case errors.As(err, &dnsErr):
+		return upstreamErrorDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return upstreamErrorRefused
+	case errors.Is(err, context.Canceled):
+		return upstreamErrorCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return upstreamErrorTimeout
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return upstreamErrorReset
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr), errors.As(err, &recordErr):
+		return upstreamErrorTLS
+	default:
+		return upstreamErrorOther
+	}
+}
+
+// logUpstreamError logs an attempt that got no response. Requests canceled
+// by the client are not the upstream's fault and only warned about.
+func logUpstreamError(logger logging.Logger, req *http.Request, err error, category string) {
+	logger = logger.With(logging.Fields{"upstream": req.URL.Host, "error_category": category})
+	if category == upstreamErrorCanceled {
+		logger.Warnf("Request to %s canceled: %v", req.URL, err)
+		return
+	}
+	logger.Errorf("Error forwarding request to %s: %v", req.URL, err)
+}
+
+// startUpstreamSpan starts the client span of an upstream attempt
+func startUpstreamSpan(ctx context.Context, tracer *tracing.Tracing, req *http.Request) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.URLFull(req.URL.String()),
+		semconv.ServerAddress(req.URL.Hostname()),
+	}
+	if port, err := strconv.Atoi(req.URL.Port()); err == nil {
+		attributes = append(attributes, semconv.ServerPort(port))
+	}
+	return tracer.Start(ctx, req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
+	)
+}
+
+// endUpstreamSpanWithError records an attempt that got no response
+func endUpstreamSpanWithError(span trace.Span, err error, category string) {
+	span.RecordError(err)
+	span.SetAttributes(semconv.ErrorTypeKey.String(category))
+	span.SetStatus(codes.Error, category)
+}
+
+// setUpstreamSpanStatus records the upstream's status code; for client spans
+// any 4xx or 5xx status is an error
+func setUpstreamSpanStatus(span trace.Span, status int) {
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+	"github.com/leo-andrei/api-gateway/internal/metrics"
+)
+
+func TestCreateProxyHandler_UpstreamMetrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "created")
+	}))
+	defer upstream.Close()
+	host := upstream.Listener.Addr().String()
+
+	metricsService := metrics.NewMetricsService()
+	handler := CreateProxyHandler(config.Route{Path: "/api", TargetURL: upstream.URL, Method: "GET"}, metricsService, nil)
+	for range 2 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api", nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metricsService.UpstreamResponses.WithLabelValues(host, "201")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricsService.UpstreamConnections.WithLabelValues(host, "false")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricsService.UpstreamConnections.WithLabelValues(host, "true")))
+	assert.Equal(t, 1, testutil.CollectAndCount(metricsService.UpstreamConnect))
+	assert.Equal(t, 0, testutil.CollectAndCount(metricsService.UpstreamErrors))
+
+	// Every response has a first byte; durations vary, so check the sample count
+	w := httptest.NewRecorder()
+	metricsService.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, w.Body.String(), fmt.Sprintf(`api_gateway_upstream_time_to_first_byte_seconds_count{upstream=%q} 2`, host))
+}
+
+func TestCreateProxyHandler_UpstreamErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	host := ln.Addr().String()
+	ln.Close()
+
+	metricsService := metrics.NewMetricsService()
+	handler := CreateProxyHandler(config.Route{Path: "/api", TargetURL: "http://" + host, Method: "GET"}, metricsService, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api", nil))
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricsService.UpstreamErrors.WithLabelValues(host, upstreamErrorRefused)))
+	assert.Equal(t, 0, testutil.CollectAndCount(metricsService.UpstreamResponses))
+}
+
+func TestUpstreamErrorCategory(t *testing.T) {
+	opError := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://upstream", Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}}
+	}
+
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{&url.Error{Err: &net.DNSError{Err: "no such host", Name: "upstream", IsNotFound: true}}, upstreamErrorDNS},
+		{opError(syscall.ECONNREFUSED), upstreamErrorRefused},
+		{opError(syscall.ECONNRESET), upstreamErrorReset},
+		{&url.Error{Err: io.EOF}, upstreamErrorReset},
+		{&url.Error{Err: context.DeadlineExceeded}, upstreamErrorTimeout},
+		{&url.Error{Err: context.Canceled}, upstreamErrorCanceled},
+		{&url.Error{Err: x509.UnknownAuthorityError{}}, upstreamErrorTLS},
+		{errors.New("malformed response"), upstreamErrorOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, upstreamErrorCategory(tt.err))
+		})
+	}
+}
+
+// fieldLogger records the fields and messages of the errors it logs
+type fieldLogger struct {
+	logging.Logger
+	fields   logging.Fields
+	messages *[]string
+}
+
+func (l fieldLogger) With(fields logging.Fields) logging.Logger {
+	merged := maps.Clone(l.fields)
+	if merged == nil {
+		merged = logging.Fields{}
+	}
+	maps.Copy(merged, fields)
+	return fieldLogger{Logger: l.Logger, fields: merged, messages: l.messages}
+}
+
+func (l fieldLogger) Errorf(format string, args ...interface{}) {
+	*l.messages = append(*l.messages, fmt.Sprintf("%s %v", fmt.Sprintf(format, args...), l.fields))
+}
+
+func TestCreateProxyHandler_LogsUpstreamErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	host := ln.Addr().String()
+	ln.Close()
+
+	var messages []string
+	logger := fieldLogger{Logger: logging.Discard(), messages: &messages}.With(logging.Fields{"request_id": "abc"})
+	handler := CreateProxyHandler(config.Route{Path: "/api", TargetURL: "http://" + host, Method: "GET"}, metrics.NewMetricsService(), nil)
+	r := httptest.NewRequest("GET", "/api", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r.WithContext(logging.NewContext(r.Context(), logger)))
+
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0], "Error forwarding request to http://"+host)
+	assert.Contains(t, messages[0], "connection refused")
+	assert.Contains(t, messages[0], "error_category:refused")
+	assert.Contains(t, messages[0], "request_id:abc")
+	assert.Contains(t, messages[0], "upstream:"+host)
+}
+
+func TestCreateProxyHandler_RecordsUpstreamForAccessLog(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	handler := CreateProxyHandler(config.Route{Path: "/api", TargetURL: upstream.URL, Method: "GET"}, metrics.NewMetricsService(), nil)
+	r := httptest.NewRequest("GET", "/api", nil)
+	ctx, access := logging.NewAccessContext(r.Context())
+	handler.ServeHTTP(httptest.NewRecorder(), r.WithContext(ctx))
+
+	assert.Equal(t, upstream.Listener.Addr().String(), access.Upstream)
+	assert.Positive(t, access.UpstreamLatency)
+}
@@ -0,0 +1,183 @@
+// Package health runs the checks registered by the gateway's subsystems and
+// reports them in the format of the IETF "Health Check Response Format for
+// HTTP APIs" draft
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ContentType is the media type of health check responses
+const ContentType = "application/health+json"
+
+// Status values, from best to worst
+const (
+	StatusPass = "pass"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Result is the outcome of a check for one component
+type Result struct {
+	ComponentID   string `json:"componentId,omitempty"`
+	ComponentType string `json:"componentType,omitempty"`
+	ObservedValue any    `json:"observedValue,omitempty"`
+	ObservedUnit  string `json:"observedUnit,omitempty"`
+	Status        string `json:"status"`
+	Time          string `json:"time,omitempty"`
+	Output        string `json:"output,omitempty"`
+}
+
+// Response is a health check response
+type Response struct {
+	Status      string              `json:"status"`
+	Description string              `json:"description,omitempty"`
+	Output      string              `json:"output,omitempty"`
+	Checks      map[string][]Result `json:"checks,omitempty"`
+}
+
+// Check reports the health of the components of a subsystem. It should return
+// promptly once ctx is done.
+type Check func(ctx context.Context) []Result
+
+// Registry holds the checks registered by subsystems
+type Registry struct {
+	mu      sync.RWMutex
+	checks  map[string]Check
+	timeout time.Duration
+}
+
+// NewRegistry creates a registry whose checks run for at most timeout, or a
+// default when it is zero
+func NewRegistry(timeout time.Duration) *Registry {
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	return &Registry{
+		checks:  make(map[string]Check),
+		timeout: timeout,
+	}
+}
+
+// Register adds or replaces a check. The name is its key in the checks
+// object, in the draft's "component:measurement" form, e.g.
+// "discovery:endpoints".
+func (r *Registry) Register(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = check
+}
+
+// Unregister removes a check
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.checks, name)
+}
+
+// Run runs all checks concurrently. The overall status is the worst status
+// of any result; a check that does not finish in time fails.
+func (r *Registry) Run(ctx context.Context) Response {
+	r.mu.RLock()
+	checks := make(map[string]Check, len(r.checks))
+	for name, check := range r.checks {
+		checks[name] = check
+	}
+	r.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	type outcome struct {
+		name    string
+		results []Result
+	}
+	outcomes := make(chan outcome, len(checks))
+	for name, check := range checks {
+		go func() {
+			outcomes <- outcome{name: name, results: check(ctx)}
+		}()
+	}
+
+	response := Response{Status: StatusPass, Checks: make(map[string][]Result, len(checks))}
+	for range checks {
+		var o outcome
+		select {
+		case o = <-outcomes:
+		case <-ctx.Done():
+			// Report the checks that are still running as failed
+			for name := range checks {
+				if _, ok := response.Checks[name]; !ok {
+					response.Checks[name] = []Result{{Status: StatusFail, Output: "check timed out"}}
+				}
+			}
+			response.Status = StatusFail
+			return response
+		}
+		response.Checks[o.name] = o.results
+		for _, result := range o.results {
+			response.Status = Worst(response.Status, result.Status)
+		}
+	}
+	return response
+}
+
+// Handler serves the result of all checks, with status 503 when any failed
+func (r *Registry) Handler(description string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		response := r.Run(req.Context())
+		response.Description = description
+		Write(w, response)
+	})
+}
+
+// Worst returns the worse of two statuses
+func Worst(a, b string) string {
+	if rank(b) > rank(a) {
+		return b
+	}
+	return a
+}
+
+func rank(status string) int {
+	switch status {
+	case StatusPass:
+		return 0
+	case StatusWarn:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Write sends a health check response, with status 503 when it failed and 200
+// otherwise
+func Write(w http.ResponseWriter, response Response) {
+	code := http.StatusOK
+	if response.Status == StatusFail {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Now formats the current time for Result.Time
+func Now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// SortResults orders results by component ID, for stable output
+func SortResults(results []Result) {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ComponentID < results[j].ComponentID
+	})
+}
@@ -0,0 +1,71 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func staticCheck(status string) Check {
+	return func(ctx context.Context) []Result {
+		return []Result{{ComponentID: "a", Status: status}}
+	}
+}
+
+func TestRegistry_Run(t *testing.T) {
+	registry := NewRegistry(0)
+	registry.Register("one:check", staticCheck(StatusPass))
+	registry.Register("two:check", staticCheck(StatusWarn))
+
+	response := registry.Run(context.Background())
+	assert.Equal(t, StatusWarn, response.Status)
+	assert.Len(t, response.Checks, 2)
+
+	registry.Register("three:check", staticCheck(StatusFail))
+	assert.Equal(t, StatusFail, registry.Run(context.Background()).Status)
+
+	registry.Unregister("three:check")
+	assert.Equal(t, StatusWarn, registry.Run(context.Background()).Status)
+}
+
+func TestRegistry_RunTimesOut(t *testing.T) {
+	registry := NewRegistry(20 * time.Millisecond)
+	registry.Register("fast:check", staticCheck(StatusPass))
+	registry.Register("slow:check", func(ctx context.Context) []Result {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	response := registry.Run(context.Background())
+	assert.Equal(t, StatusFail, response.Status)
+	assert.Equal(t, []Result{{Status: StatusFail, Output: "check timed out"}}, response.Checks["slow:check"])
+	assert.Equal(t, StatusPass, response.Checks["fast:check"][0].Status)
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	registry := NewRegistry(0)
+	registry.Register("db:connections", staticCheck(StatusFail))
+
+	w := httptest.NewRecorder()
+	registry.Handler("test").ServeHTTP(w, httptest.NewRequest("GET", "/health/details", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	var response Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, StatusFail, response.Status)
+	assert.Equal(t, "test", response.Description)
+	assert.Equal(t, []Result{{ComponentID: "a", Status: StatusFail}}, response.Checks["db:connections"])
+}
+
+func TestWorst(t *testing.T) {
+	assert.Equal(t, StatusWarn, Worst(StatusPass, StatusWarn))
+	assert.Equal(t, StatusFail, Worst(StatusFail, StatusWarn))
+	assert.Equal(t, StatusPass, Worst(StatusPass, StatusPass))
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Access collects what the handlers of a request learn that the access log
+// cannot read from the request itself: the matched route, the upstream that
+// served it and the authenticated subject. A nil *Access ignores updates, so
+// handlers can record unconditionally.
+type Access struct {
+	Route           string        // Name of the matched route
+	RouteTemplate   string        // Path template of the matched route
+	Upstream        string        // host:port of the upstream the request was forwarded to
+	UpstreamLatency time.Duration // Time until the upstream's response headers arrived
+	Subject         string        // Authenticated subject
+	ResponseHeader  http.Header
+}
+
+type accessKey struct{}
+
+// NewAccessContext returns a copy of ctx carrying a new Access for the
+// request being served
+func NewAccessContext(ctx context.Context) (context.Context, *Access) {
+	access := &Access{}
+	return context.WithValue(ctx, accessKey{}, access), access
+}
+
+// AccessFromContext returns the Access stored in ctx, or nil
+func AccessFromContext(ctx context.Context) *Access {
+	access, _ := ctx.Value(accessKey{}).(*Access)
+	return access
+}
+
+// AccessFromRequest returns the Access of r, or nil
+func AccessFromRequest(r *http.Request) *Access {
+	return AccessFromContext(r.Context())
+}
+
+// SetRoute records the route that matched the request
+func (a *Access) SetRoute(name, template string) {
+	if a == nil {
+		return
+	}
+	a.Route = name
+	a.RouteTemplate = template
+}
+
+// SetUpstream records the upstream the request was forwarded to and how long
+// it took to respond
+func (a *Access) SetUpstream(address string, latency time.Duration) {
+	if a == nil {
+		return
+	}
+	a.Upstream = address
+	a.UpstreamLatency = latency
+}
+
+// SetSubject records who the request was authenticated as
+func (a *Access) SetSubject(subject string) {
+	if a == nil {
+		return
+	}
+	a.Subject = subject
+}
@@ -0,0 +1,433 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/leo-andrei/api-gateway/internal/clientip"
+	"github.com/leo-andrei/api-gateway/internal/duration"
+	"github.com/leo-andrei/api-gateway/internal/requestid"
+)
+
+// Access log formats
+const (
+	AccessFormatDefault  = "default"  // Structured entry written in each output's format
+	AccessFormatCommon   = "common"   // Common Log Format
+	AccessFormatCombined = "combined" // Common Log Format with referer and user agent
+	AccessFormatECS      = "ecs"      // JSON with Elastic Common Schema field names
+	AccessFormatLogfmt   = "logfmt"   // key=value pairs
+)
+
+const (
+	commonTemplate   = `%{client_ip} - %{subject} [%{time_clf}] "%{method} %{uri} %{proto}" %{status} %{bytes_clf}`
+	combinedTemplate = commonTemplate + ` "%{referer}" "%{user_agent}"`
+
+	ecsVersion = "8.11.0"
+	clfTime    = "02/Jan/2006:15:04:05 -0700"
+)
+
+// AccessLogConfig selects how requests are written to the access log
+type AccessLogConfig struct {
+	Format   string `yaml:"format,omitempty" json:"format,omitempty" toml:"format,omitempty"`       // default, common, combined, ecs or logfmt, default when empty
+	Template string `yaml:"template,omitempty" json:"template,omitempty" toml:"template,omitempty"` // Custom line such as `%{client_ip} "%{method} %{path}" %{status}`, overriding the format
+
+	Exclude       []string          `yaml:"exclude,omitempty" json:"exclude,omitempty" toml:"exclude,omitempty"`                  // Paths never logged, such as /health; a trailing * matches any suffix
+	Sampling      []SamplingRule    `yaml:"sampling,omitempty" json:"sampling,omitempty" toml:"sampling,omitempty"`               // The first rule matching a request decides whether it is logged; requests matching none are
+	SlowThreshold duration.Duration `yaml:"slowThreshold,omitempty" json:"slowThreshold,omitempty" toml:"slowThreshold,omitzero"` // Requests taking at least this long are logged whatever the sampling
+}
+
+// Validate checks the format, template and sampling rules
+func (c AccessLogConfig) Validate() error {
+	var errs []error
+	switch c.Format {
+	case "", AccessFormatDefault, AccessFormatCommon, AccessFormatCombined, AccessFormatECS, AccessFormatLogfmt:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported format %q", c.Format))
+	}
+	if c.Template != "" {
+		if _, err := parseAccessTemplate(c.Template); err != nil {
+			errs = append(errs, fmt.Errorf("template: %w", err))
+		}
+	}
+	for _, pattern := range c.Exclude {
+		if !strings.HasPrefix(pattern, "/") {
+			errs = append(errs, fmt.Errorf("exclude: path %q must start with /", pattern))
+		}
+	}
+	for i, rule := range c.Sampling {
+		if err := rule.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("sampling[%d]: %w", i, err))
+		}
+	}
+	if c.SlowThreshold < 0 {
+		errs = append(errs, fmt.Errorf("slowThreshold %s must not be negative", c.SlowThreshold.Std()))
+	}
+	return errors.Join(errs...)
+}
+
+// accessRecord is everything an access log format can select from a request
+type accessRecord struct {
+	request   *http.Request
+	start     time.Time
+	duration  time.Duration
+	status    int
+	size      int
+	access    Access
+	clientIP  string
+	requestID string
+	traceID   string
+	spanID    string
+	level     logrus.Level
+	message   string
+}
+
+func newAccessRecord(r *http.Request, duration time.Duration, status int, responseSize int) *accessRecord {
+	record := &accessRecord{
+		request:   r,
+		start:     time.Now().Add(-duration),
+		duration:  duration,
+		status:    status,
+		size:      responseSize,
+		clientIP:  clientip.FromRequest(r).IP,
+		requestID: requestid.FromRequest(r),
+		level:     logrus.InfoLevel,
+		message:   "Request processed",
+	}
+	if access := AccessFromRequest(r); access != nil {
+		record.access = *access
+	}
+	if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+		record.traceID = span.TraceID().String()
+		record.spanID = span.SpanID().String()
+	}
+
+	if status >= 500 {
+		record.level = logrus.ErrorLevel
+		record.message = "Server error"
+	} else if status >= 400 {
+		record.level = logrus.WarnLevel
+		record.message = "Client error"
+	}
+	return record
+}
+
+// fields returns the entry of the default format
+func (rec *accessRecord) fields() logrus.Fields {
+	r := rec.request
+	fields := logrus.Fields{
+		"timestamp":     time.Now().Format(time.RFC3339),
+		"method":        r.Method,
+		"path":          r.URL.Path,
+		"remote_addr":   r.RemoteAddr,
+		"client_ip":     rec.clientIP,
+		"duration_ms":   rec.duration.Milliseconds(),
+		"status":        rec.status,
+		"user_agent":    r.UserAgent(),
+		"request_id":    rec.requestID,
+		"response_size": rec.size,
+	}
+	if rec.access.Route != "" {
+		fields["route"] = rec.access.Route
+	}
+	if rec.access.Upstream != "" {
+		fields["upstream"] = rec.access.Upstream
+		fields["upstream_latency_ms"] = rec.access.UpstreamLatency.Milliseconds()
+	}
+	if rec.access.Subject != "" {
+		fields["subject"] = rec.access.Subject
+	}
+	return fields
+}
+
+// accessVariables are the values a template can select with %{name}
+var accessVariables = map[string]func(rec *accessRecord) string{
+	"time":     func(rec *accessRecord) string { return rec.start.Format(time.RFC3339) },
+	"time_clf": func(rec *accessRecord) string { return rec.start.Format(clfTime) },
+	"method":   func(rec *accessRecord) string { return rec.request.Method },
+	"uri":      func(rec *accessRecord) string { return rec.request.URL.RequestURI() },
+	"path":     func(rec *accessRecord) string { return rec.request.URL.Path },
+	"query":    func(rec *accessRecord) string { return rec.request.URL.RawQuery },
+	"proto":    func(rec *accessRecord) string { return rec.request.Proto },
+	"host":     func(rec *accessRecord) string { return rec.request.Host },
+	"status":   func(rec *accessRecord) string { return strconv.Itoa(rec.status) },
+	"bytes":    func(rec *accessRecord) string { return strconv.Itoa(rec.size) },
+	"bytes_clf": func(rec *accessRecord) string {
+		if rec.size == 0 {
+			return ""
+		}
+		return strconv.Itoa(rec.size)
+	},
+	"request_bytes": func(rec *accessRecord) string {
+		if rec.request.ContentLength < 0 {
+			return ""
+		}
+		return strconv.FormatInt(rec.request.ContentLength, 10)
+	},
+	"duration_ms":    func(rec *accessRecord) string { return strconv.FormatInt(rec.duration.Milliseconds(), 10) },
+	"duration_us":    func(rec *accessRecord) string { return strconv.FormatInt(rec.duration.Microseconds(), 10) },
+	"remote_addr":    func(rec *accessRecord) string { return rec.request.RemoteAddr },
+	"client_ip":      func(rec *accessRecord) string { return rec.clientIP },
+	"user_agent":     func(rec *accessRecord) string { return rec.request.UserAgent() },
+	"referer":        func(rec *accessRecord) string { return rec.request.Referer() },
+	"request_id":     func(rec *accessRecord) string { return rec.requestID },
+	"trace_id":       func(rec *accessRecord) string { return rec.traceID },
+	"span_id":        func(rec *accessRecord) string { return rec.spanID },
+	"route":          func(rec *accessRecord) string { return rec.access.Route },
+	"route_template": func(rec *accessRecord) string { return rec.access.RouteTemplate },
+	"upstream":       func(rec *accessRecord) string { return rec.access.Upstream },
+	"upstream_latency_ms": func(rec *accessRecord) string {
+		if rec.access.Upstream == "" {
+			return ""
+		}
+		return strconv.FormatInt(rec.access.UpstreamLatency.Milliseconds(), 10)
+	},
+	"subject": func(rec *accessRecord) string { return rec.access.Subject },
+}
+
+// accessFormatter turns a request into one access log line
+type accessFormatter interface {
+	format(rec *accessRecord) []byte
+}
+
+// newAccessFormatter returns the formatter of cfg, or nil for the default
+// format
+func newAccessFormatter(cfg AccessLogConfig) (accessFormatter, error) {
+	template := cfg.Template
+	switch {
+	case template != "":
+	case cfg.Format == AccessFormatCommon:
+		template = commonTemplate
+	case cfg.Format == AccessFormatCombined:
+		template = combinedTemplate
+	}
+	if template != "" {
+		parsed, err := parseAccessTemplate(template)
+		if err != nil {
+			return nil, err
+		}
+		return parsed, nil
+	}
+
+	switch cfg.Format {
+	case AccessFormatECS:
+		return ecsFormatter{}, nil
+	case AccessFormatLogfmt:
+		return logfmtFormatter{}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// accessTemplate is a parsed template: literal text alternating with values
+type accessTemplate []templateSegment
+
+type templateSegment struct {
+	literal string
+	value   func(rec *accessRecord) string
+}
+
+// parseAccessTemplate parses a template. %{name} selects a variable,
+// %{request_header:Name} and %{response_header:Name} a header, and %% is a
+// literal percent sign.
+func parseAccessTemplate(text string) (accessTemplate, error) {
+	var template accessTemplate
+	var literal strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '%' {
+			literal.WriteByte(text[i])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(text[i:], "%%"):
+			literal.WriteByte('%')
+			i++
+			continue
+		case !strings.HasPrefix(text[i:], "%{"):
+			return nil, fmt.Errorf(`"%%" at offset %d must be followed by "{" or "%%"`, i)
+		}
+		end := strings.IndexByte(text[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated %%{ at offset %d", i)
+		}
+		value, err := templateValue(text[i+2 : i+end])
+		if err != nil {
+			return nil, err
+		}
+		if literal.Len() > 0 {
+			template = append(template, templateSegment{literal: literal.String()})
+			literal.Reset()
+		}
+		template = append(template, templateSegment{value: value})
+		i += end
+	}
+	if literal.Len() > 0 {
+		template = append(template, templateSegment{literal: literal.String()})
+	}
+	return template, nil
+}
+
+func templateValue(name string) (func(rec *accessRecord) string, error) {
+	if kind, header, ok := strings.Cut(name, ":"); ok {
+		header = http.CanonicalHeaderKey(header)
+		switch kind {
+		case "request_header":
+			return func(rec *accessRecord) string {
+				return strings.Join(rec.request.Header.Values(header), ", ")
+			}, nil
+		case "response_header":
+			return func(rec *accessRecord) string {
+				return strings.Join(rec.access.ResponseHeader.Values(header), ", ")
+			}, nil
+		default:
+			return nil, fmt.Errorf("unknown variable %q", name)
+		}
+	}
+	value, ok := accessVariables[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable %q", name)
+	}
+	return value, nil
+}
+
+// format writes the template, with "-" for empty values. Values are escaped
+// like Apache does, so clients cannot forge lines or break quoting.
+func (t accessTemplate) format(rec *accessRecord) []byte {
+	var line bytes.Buffer
+	for _, segment := range t {
+		if segment.value == nil {
+			line.WriteString(segment.literal)
+			continue
+		}
+		value := segment.value(rec)
+		if value == "" {
+			line.WriteByte('-')
+			continue
+		}
+		escapeAccessValue(&line, value)
+	}
+	line.WriteByte('\n')
+	return line.Bytes()
+}
+
+func escapeAccessValue(line *bytes.Buffer, value string) {
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '"' || c == '\\':
+			line.WriteByte('\\')
+			line.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(line, `\x%02x`, c)
+		default:
+			line.WriteByte(c)
+		}
+	}
+}
+
+// logfmtFormatter writes the default fields as key=value pairs, leaving out
+// empty values
+type logfmtFormatter struct{}
+
+func (logfmtFormatter) format(rec *accessRecord) []byte {
+	pairs := [][2]string{
+		{"time", accessVariables["time"](rec)},
+		{"level", rec.level.String()},
+		{"msg", rec.message},
+	}
+	for _, name := range []string{
+		"method", "path", "query", "proto", "status", "bytes", "duration_ms", "client_ip", "remote_addr",
+		"user_agent", "referer", "request_id", "trace_id", "route", "route_template", "upstream",
+		"upstream_latency_ms", "subject",
+	} {
+		pairs = append(pairs, [2]string{name, accessVariables[name](rec)})
+	}
+
+	var line bytes.Buffer
+	for _, pair := range pairs {
+		if pair[1] == "" {
+			continue
+		}
+		if line.Len() > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(pair[0])
+		line.WriteByte('=')
+		if strings.ContainsFunc(pair[1], func(r rune) bool { return r <= ' ' || r == '=' || r == '"' || r == 0x7f }) {
+			line.WriteString(strconv.Quote(pair[1]))
+		} else {
+			line.WriteString(pair[1])
+		}
+	}
+	line.WriteByte('\n')
+	return line.Bytes()
+}
+
+// ecsFormatter writes a JSON object with Elastic Common Schema field names.
+// Fields ECS has no name for are under "gateway".
+type ecsFormatter struct{}
+
+func (ecsFormatter) format(rec *accessRecord) []byte {
+	r := rec.request
+	request := map[string]any{"method": r.Method}
+	setIfNotEmpty(request, "id", rec.requestID)
+	setIfNotEmpty(request, "referrer", r.Referer())
+	if r.ContentLength >= 0 {
+		request["body"] = map[string]any{"bytes": r.ContentLength}
+	}
+	url := map[string]any{"path": r.URL.Path, "original": r.URL.RequestURI()}
+	setIfNotEmpty(url, "query", r.URL.RawQuery)
+
+	entry := map[string]any{
+		"@timestamp": rec.start.Format(time.RFC3339Nano),
+		"message":    rec.message,
+		"log":        map[string]any{"level": rec.level.String()},
+		"ecs":        map[string]any{"version": ecsVersion},
+		"event":      map[string]any{"duration": rec.duration.Nanoseconds()},
+		"http": map[string]any{
+			"version":  strings.TrimPrefix(r.Proto, "HTTP/"),
+			"request":  request,
+			"response": map[string]any{"status_code": rec.status, "body": map[string]any{"bytes": rec.size}},
+		},
+		"url":    url,
+		"client": map[string]any{"ip": rec.clientIP},
+		"source": map[string]any{"address": r.RemoteAddr},
+	}
+	if agent := r.UserAgent(); agent != "" {
+		entry["user_agent"] = map[string]any{"original": agent}
+	}
+	if rec.access.Subject != "" {
+		entry["user"] = map[string]any{"name": rec.access.Subject}
+	}
+	if rec.traceID != "" {
+		entry["trace"] = map[string]any{"id": rec.traceID}
+		entry["span"] = map[string]any{"id": rec.spanID}
+	}
+	gateway := map[string]any{}
+	if rec.access.Route != "" {
+		gateway["route"] = map[string]any{"name": rec.access.Route, "template": rec.access.RouteTemplate}
+	}
+	if rec.access.Upstream != "" {
+		gateway["upstream"] = map[string]any{"address": rec.access.Upstream, "duration": rec.access.UpstreamLatency.Nanoseconds()}
+	}
+	if len(gateway) > 0 {
+		entry["gateway"] = gateway
+	}
+
+	// Only maps, strings and numbers are marshalled, which cannot fail
+	line, _ := json.Marshal(entry)
+	return append(line, '\n')
+}
+
+func setIfNotEmpty(m map[string]any, key, value string) {
+	if value != "" {
+		m[key] = value
+	}
+}
@@ -0,0 +1,155 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/leo-andrei/api-gateway/internal/clientip"
+	"github.com/leo-andrei/api-gateway/internal/duration"
+	"github.com/leo-andrei/api-gateway/internal/requestid"
+)
+
+// newTestAccessRecord returns the record of a request to the users route
+// that was forwarded to an upstream
+func newTestAccessRecord(t *testing.T) *accessRecord {
+	r := httptest.NewRequest("GET", "/users/42?expand=orders", nil)
+	r.RemoteAddr = "10.0.0.1:51234"
+	r.Header.Set("User-Agent", `curl/8.5 "quoted"`)
+	r.Header.Set("Referer", "https://example.com/")
+	r.Header.Set("X-Tenant", "acme")
+	ctx := clientip.NewContext(r.Context(), clientip.Client{IP: "203.0.113.7", Peer: "10.0.0.1"})
+	ctx = requestid.NewContext(ctx, "abc-123")
+	ctx, access := NewAccessContext(ctx)
+	access.SetRoute("get-users", "/users/{id}")
+	access.SetUpstream("users:8081", 12*time.Millisecond)
+	access.SetSubject("alice")
+	access.ResponseHeader = http.Header{"Content-Type": {"application/json"}}
+
+	record := newAccessRecord(r.WithContext(ctx), 15*time.Millisecond, http.StatusOK, 512)
+	record.start = time.Date(2024, time.March, 5, 14, 7, 9, 0, time.FixedZone("", 3600))
+	return record
+}
+
+func TestAccessFormats(t *testing.T) {
+	tests := []struct {
+		config AccessLogConfig
+		line   string
+	}{
+		{
+			config: AccessLogConfig{Format: AccessFormatCommon},
+			line:   `203.0.113.7 - alice [05/Mar/2024:14:07:09 +0100] "GET /users/42?expand=orders HTTP/1.1" 200 512`,
+		},
+		{
+			config: AccessLogConfig{Format: AccessFormatCombined},
+			line:   `203.0.113.7 - alice [05/Mar/2024:14:07:09 +0100] "GET /users/42?expand=orders HTTP/1.1" 200 512 "https://example.com/" "curl/8.5 \"quoted\""`,
+		},
+		{
+			config: AccessLogConfig{Format: AccessFormatLogfmt},
+			line: `time=2024-03-05T14:07:09+01:00 level=info msg="Request processed" method=GET path=/users/42 query="expand=orders" ` +
+				`proto=HTTP/1.1 status=200 bytes=512 duration_ms=15 client_ip=203.0.113.7 remote_addr=10.0.0.1:51234 ` +
+				`user_agent="curl/8.5 \"quoted\"" referer=https://example.com/ request_id=abc-123 route=get-users ` +
+				`route_template=/users/{id} upstream=users:8081 upstream_latency_ms=12 subject=alice`,
+		},
+		{
+			config: AccessLogConfig{Format: AccessFormatCommon, Template: `%{route} %{upstream} %{upstream_latency_ms}ms %{request_header:x-tenant} %{response_header:Content-Type} %{request_header:X-Missing} 100%%`},
+			line:   `get-users users:8081 12ms acme application/json - 100%`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.config.Format+tt.config.Template, func(t *testing.T) {
+			formatter, err := newAccessFormatter(tt.config)
+			require.NoError(t, err)
+			assert.Equal(t, tt.line+"\n", string(formatter.format(newTestAccessRecord(t))))
+		})
+	}
+}
+
+func TestAccessFormat_ECS(t *testing.T) {
+	formatter, err := newAccessFormatter(AccessLogConfig{Format: AccessFormatECS})
+	require.NoError(t, err)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(formatter.format(newTestAccessRecord(t)), &entry))
+	assert.Equal(t, "2024-03-05T14:07:09+01:00", entry["@timestamp"])
+	assert.Equal(t, map[string]any{"level": "info"}, entry["log"])
+	assert.Equal(t, map[string]any{"duration": float64(15 * time.Millisecond)}, entry["event"])
+	assert.Equal(t, map[string]any{
+		"version":  "1.1",
+		"request":  map[string]any{"method": "GET", "id": "abc-123", "referrer": "https://example.com/", "body": map[string]any{"bytes": float64(0)}},
+		"response": map[string]any{"status_code": float64(200), "body": map[string]any{"bytes": float64(512)}},
+	}, entry["http"])
+	assert.Equal(t, map[string]any{"path": "/users/42", "query": "expand=orders", "original": "/users/42?expand=orders"}, entry["url"])
+	assert.Equal(t, map[string]any{"ip": "203.0.113.7"}, entry["client"])
+	assert.Equal(t, map[string]any{"name": "alice"}, entry["user"])
+	assert.Equal(t, map[string]any{
+		"route":    map[string]any{"name": "get-users", "template": "/users/{id}"},
+		"upstream": map[string]any{"address": "users:8081", "duration": float64(12 * time.Millisecond)},
+	}, entry["gateway"])
+}
+
+func TestAccessTemplate_Escaping(t *testing.T) {
+	template, err := parseAccessTemplate(`"%{request_header:X-Note}"`)
+	require.NoError(t, err)
+
+	record := newTestAccessRecord(t)
+	record.request.Header.Set("X-Note", "a\"b\\c\nforged line")
+	assert.Equal(t, `"a\"b\\c\x0aforged line"`+"\n", string(template.format(record)))
+}
+
+func TestAccessLogConfig_Validate(t *testing.T) {
+	tests := []struct {
+		config AccessLogConfig
+		err    string
+	}{
+		{config: AccessLogConfig{}},
+		{config: AccessLogConfig{Format: AccessFormatECS}},
+		{config: AccessLogConfig{Template: `%{method} %{request_header:Accept} %%`}},
+		{config: AccessLogConfig{Format: "apache"}, err: `unsupported format "apache"`},
+		{config: AccessLogConfig{Template: `%{bogus}`}, err: `template: unknown variable "bogus"`},
+		{config: AccessLogConfig{Template: `%{cookie:session}`}, err: `template: unknown variable "cookie:session"`},
+		{config: AccessLogConfig{Template: `%{method`}, err: "template: unterminated %{ at offset 0"},
+		{config: AccessLogConfig{Template: `%h %s`}, err: `template: "%" at offset 0 must be followed by "{" or "%"`},
+		{config: AccessLogConfig{Exclude: []string{"/health", "/internal/*"}, Sampling: []SamplingRule{{Status: "2xx", Rate: 0.01}}, SlowThreshold: duration.Duration(time.Second)}},
+		{config: AccessLogConfig{Exclude: []string{"metrics"}}, err: `exclude: path "metrics" must start with /`},
+		{config: AccessLogConfig{Sampling: []SamplingRule{{Rate: 1}, {Status: "2xy", Rate: 1}}}, err: `sampling[1]: invalid status "2xy", expected a class such as 2xx or a code such as 404`},
+		{config: AccessLogConfig{SlowThreshold: duration.Duration(-time.Second)}, err: "slowThreshold -1s must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.config.Format+tt.config.Template+tt.err, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLogService_AccessLogFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	logService := NewLogService(LoggingConfig{
+		Format:    FormatJSON,
+		Outputs:   []OutputConfig{{Type: OutputFile, Path: path}},
+		AccessLog: AccessLogConfig{Template: `%{method} %{path} %{status}`},
+	})
+
+	logService.LogRequest(httptest.NewRequest("GET", "/users", nil), time.Millisecond, http.StatusOK, 0)
+	logService.LogRequest(httptest.NewRequest("POST", "/orders", nil), time.Millisecond, http.StatusBadGateway, 0)
+	require.NoError(t, logService.Shutdown(context.Background()))
+
+	// Lines are written as formatted, not wrapped in the output's JSON
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GET /users 200", "POST /orders 502"}, strings.Split(strings.TrimSpace(string(written)), "\n"))
+}
@@ -1,21 +1,155 @@
 package logging
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/leo-andrei/api-gateway/internal/duration"
+)
+
+// Formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
 )
 
 // LoggingConfig holds logging configuration
 type LoggingConfig struct {
-	Level  string `yaml:"level"`
-	Format string `yaml:"format"`
+	Level         string            `yaml:"level" json:"level" toml:"level"`
+	Format        string            `yaml:"format" json:"format" toml:"format"`
+	Outputs       []OutputConfig    `yaml:"outputs,omitempty" json:"outputs,omitempty" toml:"outputs,omitempty"`                  // stdout and a rotated logs/api-gateway.log when empty
+	BufferSize    int               `yaml:"bufferSize,omitempty" json:"bufferSize,omitempty" toml:"bufferSize,omitzero"`          // Request log entries queued for writing, 1000 when zero
+	Backpressure  string            `yaml:"backpressure,omitempty" json:"backpressure,omitempty" toml:"backpressure,omitempty"`   // What happens when the queue is full: drop-newest (default), drop-oldest or block
+	BlockTimeout  duration.Duration `yaml:"blockTimeout,omitempty" json:"blockTimeout,omitempty" toml:"blockTimeout,omitzero"`    // How long the block policy waits for room before dropping, 1s when zero
+	BatchSize     int               `yaml:"batchSize,omitempty" json:"batchSize,omitempty" toml:"batchSize,omitzero"`             // Request log entries written together, 5 when zero
+	FlushInterval duration.Duration `yaml:"flushInterval,omitempty" json:"flushInterval,omitempty" toml:"flushInterval,omitzero"` // How often a partial batch is written, 5s when zero
+	AccessLog     AccessLogConfig   `yaml:"accessLog,omitempty" json:"accessLog,omitempty" toml:"accessLog,omitempty"`
+	Redaction     RedactionConfig   `yaml:"redaction,omitempty" json:"redaction,omitempty" toml:"redaction,omitempty"`
 }
 
+// Validate checks the levels, formats, outputs and redaction rules
+func (c LoggingConfig) Validate() error {
+	var errs []error
+	if err := validateLevelAndFormat(c.Level, c.Format); err != nil {
+		errs = append(errs, err)
+	}
+	if c.BufferSize < 0 {
+		errs = append(errs, fmt.Errorf("bufferSize %d must not be negative", c.BufferSize))
+	}
+	switch c.Backpressure {
+	case "", BackpressureDropNewest, BackpressureDropOldest, BackpressureBlock:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported backpressure policy %q", c.Backpressure))
+	}
+	if c.BlockTimeout < 0 {
+		errs = append(errs, fmt.Errorf("blockTimeout %s must not be negative", c.BlockTimeout.Std()))
+	}
+	if c.BatchSize < 0 {
+		errs = append(errs, fmt.Errorf("batchSize %d must not be negative", c.BatchSize))
+	}
+	if c.FlushInterval < 0 {
+		errs = append(errs, fmt.Errorf("flushInterval %s must not be negative", c.FlushInterval.Std()))
+	}
+	if err := c.AccessLog.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("accessLog: %w", err))
+	}
+	if err := c.Redaction.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("redaction: %w", err))
+	}
+	for i, output := range c.Outputs {
+		if err := output.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("outputs[%d]: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateLevelAndFormat(level, format string) error {
+	var errs []error
+	if level != "" {
+		if _, err := logrus.ParseLevel(level); err != nil {
+			errs = append(errs, fmt.Errorf("unsupported level %q", level))
+		}
+	}
+	switch format {
+	case "", FormatJSON, FormatText:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported format %q", format))
+	}
+	return errors.Join(errs...)
+}
+
+// Fields are key/value pairs attached to log entries
+type Fields map[string]any
+
 type Logger interface {
+	Debug(msg string)
+	Debugf(format string, args ...interface{})
 	Info(msg string)
 	Infof(format string, args ...interface{})
+	Warn(msg string)
+	Warnf(format string, args ...interface{})
+	Error(msg string)
+	Errorf(format string, args ...interface{})
 	Fatal(msg string)
 	Fatalf(format string, args ...interface{})
+	// With returns a child logger adding fields to every entry
+	With(fields Fields) Logger
 	LogRequest(r *http.Request, duration time.Duration, status int, responseSize int)
-	Shutdown()
+	// Shutdown flushes and closes the logger, waiting at most until ctx is
+	// done
+	Shutdown(ctx context.Context) error
 }
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying logger, usually a child with the
+// fields of the request being served
+func NewContext(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, adding the IDs of the current
+// trace span when there is one. Without a stored logger, entries are
+// discarded.
+func FromContext(ctx context.Context) Logger {
+	logger, ok := ctx.Value(contextKey{}).(Logger)
+	if !ok {
+		return discard{}
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		logger = logger.With(Fields{
+			"trace_id": span.TraceID().String(),
+			"span_id":  span.SpanID().String(),
+		})
+	}
+	return logger
+}
+
+// Discard returns a logger that drops every entry
+func Discard() Logger {
+	return discard{}
+}
+
+// discard is the logger of contexts without one
+type discard struct{}
+
+func (discard) Debug(string)                                      {}
+func (discard) Debugf(string, ...interface{})                     {}
+func (discard) Info(string)                                       {}
+func (discard) Infof(string, ...interface{})                      {}
+func (discard) Warn(string)                                       {}
+func (discard) Warnf(string, ...interface{})                      {}
+func (discard) Error(string)                                      {}
+func (discard) Errorf(string, ...interface{})                     {}
+func (discard) Fatal(string)                                      {}
+func (discard) Fatalf(string, ...interface{})                     {}
+func (d discard) With(Fields) Logger                              { return d }
+func (discard) LogRequest(*http.Request, time.Duration, int, int) {}
+func (discard) Shutdown(context.Context) error                    { return nil }
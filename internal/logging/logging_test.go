@@ -1,9 +1,19 @@
 package logging
 
 import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/leo-andrei/api-gateway/internal/duration"
 )
 
 func TestNewLogService(t *testing.T) {
@@ -16,3 +26,105 @@ func TestNewLogService(t *testing.T) {
 	assert.NotNil(t, logService)
 	assert.Equal(t, "info", logService.logger.GetLevel().String())
 }
+
+// newFileLogService returns a LogService writing JSON to a file, and a
+// function returning the entries written so far
+func newFileLogService(t *testing.T, config LoggingConfig) (*LogService, func() []map[string]any) {
+	path := filepath.Join(t.TempDir(), "gateway.log")
+	if config.Outputs == nil {
+		config.Outputs = []OutputConfig{{Type: OutputFile, Path: path}}
+	}
+	logService := NewLogService(config)
+	t.Cleanup(func() { logService.Shutdown(context.Background()) })
+	return logService, func() []map[string]any {
+		return readEntries(t, path)
+	}
+}
+
+func readEntries(t *testing.T, path string) []map[string]any {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var entries []map[string]any
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var entry map[string]any
+		require.NoError(t, decoder.Decode(&entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLogService_Levels(t *testing.T) {
+	logService, read := newFileLogService(t, LoggingConfig{Level: "debug", Format: "json"})
+
+	logService.Debugf("debug %d", 1)
+	logService.Warn("warning")
+	logService.Errorf("error %s", "cause")
+
+	entries := read()
+	require.Len(t, entries, 3)
+	assert.Equal(t, "debug", entries[0]["level"])
+	assert.Equal(t, "debug 1", entries[0]["msg"])
+	assert.Equal(t, "warning", entries[1]["level"])
+	assert.Equal(t, "error", entries[2]["level"])
+	assert.Equal(t, "error cause", entries[2]["msg"])
+}
+
+func TestLogService_With(t *testing.T) {
+	logService, read := newFileLogService(t, LoggingConfig{Level: "debug", Format: "json"})
+
+	child := logService.With(Fields{"request_id": "abc", "route": "users"})
+	child.With(Fields{"route": "orders"}).Info("child")
+	logService.Info("parent")
+
+	entries := read()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "abc", entries[0]["request_id"])
+	assert.Equal(t, "orders", entries[0]["route"])
+	assert.NotContains(t, entries[1], "request_id")
+}
+
+func TestFromContext(t *testing.T) {
+	// Without a logger, entries are discarded
+	assert.NotPanics(t, func() { FromContext(context.Background()).Error("lost") })
+
+	logService, read := newFileLogService(t, LoggingConfig{Level: "debug", Format: "json"})
+	ctx := NewContext(context.Background(), logService.With(Fields{"request_id": "abc"}))
+	FromContext(ctx).Info("no span")
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx = trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+	FromContext(ctx).Info("in span")
+
+	entries := read()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "abc", entries[0]["request_id"])
+	assert.NotContains(t, entries[0], "trace_id")
+	assert.Equal(t, "abc", entries[1]["request_id"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", entries[1]["trace_id"])
+	assert.Equal(t, "00f067aa0ba902b7", entries[1]["span_id"])
+}
+
+func TestLogService_ShutdownFlushesRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.log")
+	logService := NewLogService(LoggingConfig{
+		Format:        "json",
+		Outputs:       []OutputConfig{{Type: OutputFile, Path: path}},
+		BatchSize:     100,
+		FlushInterval: duration.Duration(time.Hour),
+	})
+
+	for _, status := range []int{200, 404, 502} {
+		logService.LogRequest(httptest.NewRequest("GET", "/users", nil), time.Millisecond, status, 0)
+	}
+	require.NoError(t, logService.Shutdown(context.Background()))
+
+	entries := readEntries(t, path)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "Request processed", entries[0]["msg"])
+	assert.Equal(t, "Client error", entries[1]["msg"])
+	assert.Equal(t, "Server error", entries[2]["msg"])
+}
@@ -1,185 +1,225 @@
 package logging
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"strconv"
-	"sync"
 	"time"
 
-	"github.com/natefinch/lumberjack"
 	"github.com/sirupsen/logrus"
+
+	"github.com/leo-andrei/api-gateway/internal/metrics"
 )
 
+// LogEntry is a request log entry waiting to be written: either a message
+// with fields, formatted by each output, or a preformatted access log line
 type LogEntry struct {
 	Level   logrus.Level
 	Message string
 	Fields  logrus.Fields
+	Line    []byte
 }
 
-// LogService handles structured logging
+// LogService handles structured logging. Child loggers created with With
+// share the outputs and the request log pipeline of their parent.
 type LogService struct {
-	logger  *logrus.Logger
-	mu      sync.Mutex
-	logChan chan LogEntry
+	logger       *logrus.Logger
+	outputs      []*output
+	fields       logrus.Fields
+	pipeline     *pipeline
+	accessFormat accessFormatter
+	accessFilter *accessFilter
+	redactor     *Redactor
 }
 
 var _ Logger = (*LogService)(nil)
 
-// NewLogService initializes a new logging service
-func NewLogService(config LoggingConfig) *LogService {
-	logger := logrus.New()
-
-	// Set up log rotation - @TODO: move to config or env variables
-	fileLogger := &lumberjack.Logger{
-		Filename:   "logs/api-gateway.log",
-		MaxSize:    10, // Max megabytes before log is rotated
-		MaxBackups: 3,  // Max number of old log files to keep
-		MaxAge:     28, // Max number of days to retain old log files
-		Compress:   true,
-	}
+type options struct {
+	metrics metrics.Metrics
+}
 
-	// Write logs to both file and stdout
-	logger.SetOutput(io.MultiWriter(fileLogger, os.Stdout))
+// Option configures a LogService
+type Option func(*options)
 
-	// Set log level
-	level, err := logrus.ParseLevel(config.Level)
-	if err != nil {
-		level = logrus.InfoLevel // Default to Info level if parsing fails
+// WithMetrics counts the request log entries that are dropped
+func WithMetrics(metrics metrics.Metrics) Option {
+	return func(o *options) {
+		o.metrics = metrics
 	}
-	logger.SetLevel(level)
-
-	// Set log format
-	if config.Format == "json" {
-		logger.SetFormatter(&logrus.JSONFormatter{})
-	} else {
-		logger.SetFormatter(&logrus.TextFormatter{
-			FullTimestamp: true,
-		})
+}
+
+// NewLogService initializes a new logging service writing to the configured
+// outputs
+func NewLogService(config LoggingConfig, opts ...Option) *LogService {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
-	// Read buffered channel size from environment variable
-	logChanSize := 1000 // Default value
-	if envLogChanSize := os.Getenv("LOG_BUFFERED_CHANNEL_SIZE"); envLogChanSize != "" {
-		if size, err := strconv.Atoi(envLogChanSize); err == nil && size > 0 {
-			logChanSize = size
-		}
+
+	configs := config.Outputs
+	if len(configs) == 0 {
+		configs = defaultOutputs
 	}
-	// Read batch size from environment variable
-	batchSize := 5 // Default value
 
-	if envBatchSize := os.Getenv("LOG_BATCH_SIZE"); envBatchSize != "" {
-		if size, err := strconv.Atoi(envBatchSize); err == nil && size > 0 {
-			batchSize = size
+	// Entries are written by the outputs, each with its own level and format.
+	// The logger only filters out entries that no output wants.
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.SetFormatter(discardFormatter{})
+	logger.SetLevel(logrus.PanicLevel)
+	redactor := NewRedactor(config.Redaction)
+	logger.AddHook(redactionHook{redactor: redactor})
+	outputs := make([]*output, 0, len(configs))
+	for _, cfg := range configs {
+		output := newOutput(cfg, config)
+		logger.AddHook(output)
+		outputs = append(outputs, output)
+		if output.level > logger.GetLevel() {
+			logger.SetLevel(output.level)
 		}
 	}
 
 	logService := &LogService{
-		logger:  logger,
-		logChan: make(chan LogEntry, logChanSize), // Buffered channel for log entries
+		logger:   logger,
+		outputs:  outputs,
+		redactor: redactor,
 	}
-
-	// Start a goroutine to process log entries asynchronously
-	go logService.processLogs(batchSize)
-
+	logService.pipeline = newPipeline(config, logService.flushLogs, o.metrics)
+	accessFormat, err := newAccessFormatter(config.AccessLog)
+	if err != nil {
+		logService.Errorf("Invalid access log format, using the default: %v", err)
+	}
+	logService.accessFormat = accessFormat
+	logService.accessFilter = newAccessFilter(config.AccessLog)
 	return logService
 }
 
-func (l *LogService) processLogs(batchSize int) {
-	if batchSize <= 0 {
-		batchSize = 5 // Default to 1000 if not set or invalid
-	}
-	batch := make([]LogEntry, 0, batchSize)
-	ticker := time.NewTicker(5 * time.Second) // @TODO move the ticker timing to config or env variable
-	defer ticker.Stop()
-	for {
-		select {
-		case entry, ok := <-l.logChan:
-			if !ok {
-				// Channel is closed, flush remaining logs and exit
-				if len(batch) > 0 {
-					l.flushLogs(batch)
+func (l *LogService) flushLogs(batch []LogEntry) {
+	for _, entry := range batch {
+		if entry.Line == nil {
+			l.logger.WithFields(entry.Fields).Log(entry.Level, entry.Message)
+			continue
+		}
+		for _, output := range l.outputs {
+			if output.level >= entry.Level {
+				if err := output.writeLine(entry.Level, entry.Line); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to write access log: %v\n", err)
 				}
-				return
-			}
-			batch = append(batch, entry)
-			if len(batch) >= 5 {
-				l.flushLogs(batch)
-				batch = batch[:0]
-			}
-		case <-ticker.C:
-			if len(batch) > 0 {
-				l.flushLogs(batch)
-				batch = batch[:0]
 			}
 		}
 	}
 }
 
-func (l *LogService) flushLogs(batch []LogEntry) {
-	for _, entry := range batch {
-		l.logger.WithFields(entry.Fields).Log(entry.Level, entry.Message)
+// LogRequest logs information about a request in the access log format,
+// unless it is excluded or sampled out. Sensitive headers and query
+// parameters are redacted.
+func (l *LogService) LogRequest(r *http.Request, duration time.Duration, status int, responseSize int) {
+	record := newAccessRecord(r, duration, status, responseSize)
+	if !l.accessFilter.keep(record) {
+		return
+	}
+	record.request = l.redactor.request(record.request)
+	record.access.ResponseHeader = l.redactor.Header(record.access.ResponseHeader)
+	if l.accessFormat != nil {
+		line := l.redactor.String(string(l.accessFormat.format(record)))
+		l.pipeline.send(LogEntry{Level: record.level, Line: []byte(line)})
+		return
 	}
-}
 
-// LogRequest logs information about a request
-func (l *LogService) LogRequest(r *http.Request, duration time.Duration, status int, responseSize int) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	fields := logrus.Fields{
-		"timestamp":     time.Now().Format(time.RFC3339),
-		"method":        r.Method,
-		"path":          r.URL.Path,
-		"remote_addr":   r.RemoteAddr,
-		"duration_ms":   duration.Milliseconds(),
-		"status":        status,
-		"user_agent":    r.UserAgent(),
-		"request_id":    r.Header.Get("X-Request-ID"),
-		"response_size": responseSize,
+	fields := record.fields()
+	for key, value := range l.fields {
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
 	}
+	l.pipeline.send(LogEntry{Level: record.level, Message: record.message, Fields: fields})
+}
 
-	level := logrus.InfoLevel
-	message := "Request processed"
+// entry returns a logrus entry with the fields of this logger
+func (l *LogService) entry() *logrus.Entry {
+	return l.logger.WithFields(l.fields)
+}
 
-	if status >= 500 {
-		level = logrus.ErrorLevel
-		message = "Server error"
-	} else if status >= 400 {
-		level = logrus.WarnLevel
-		message = "Client error"
+// With returns a child logger adding fields to every entry
+func (l *LogService) With(fields Fields) Logger {
+	merged := make(logrus.Fields, len(l.fields)+len(fields))
+	for key, value := range l.fields {
+		merged[key] = value
 	}
-
-	// Send the log entry to the channel
-	select {
-	case l.logChan <- LogEntry{Level: level, Message: message, Fields: fields}:
-	default:
-		// Drop the log entry if the channel is full to avoid blocking
-		fmt.Println("Log channel is full, dropping log entry")
+	for key, value := range fields {
+		merged[key] = value
 	}
+	child := *l
+	child.fields = merged
+	return &child
+}
+
+// Debug logs a debug message
+func (l *LogService) Debug(msg string) {
+	l.entry().Debug(msg)
+}
+
+// Debugf logs a formatted debug message
+func (l *LogService) Debugf(format string, args ...interface{}) {
+	l.entry().Debugf(format, args...)
 }
 
 // Info logs an informational message
 func (l *LogService) Info(msg string) {
-	l.logger.Info(msg)
+	l.entry().Info(msg)
 }
 
 // Infof logs a formatted informational message
 func (l *LogService) Infof(format string, args ...interface{}) {
-	l.logger.Infof(format, args...)
+	l.entry().Infof(format, args...)
+}
+
+// Warn logs a warning
+func (l *LogService) Warn(msg string) {
+	l.entry().Warn(msg)
+}
+
+// Warnf logs a formatted warning
+func (l *LogService) Warnf(format string, args ...interface{}) {
+	l.entry().Warnf(format, args...)
+}
+
+// Error logs an error
+func (l *LogService) Error(msg string) {
+	l.entry().Error(msg)
+}
+
+// Errorf logs a formatted error
+func (l *LogService) Errorf(format string, args ...interface{}) {
+	l.entry().Errorf(format, args...)
 }
 
 // Fatal logs a fatal message and exits the application
 func (l *LogService) Fatal(msg string) {
-	l.logger.Fatal(msg)
+	l.entry().Fatal(msg)
 }
 
 // Fatalf logs a formatted fatal message and exits the application
 func (l *LogService) Fatalf(format string, args ...interface{}) {
-	l.logger.Fatalf(format, args...)
+	l.entry().Fatalf(format, args...)
 }
 
-func (l *LogService) Shutdown() {
-	close(l.logChan) // Close the channel to stop the goroutine
+// Shutdown writes the queued request log entries, waiting until they are
+// written or ctx is done, and then closes the outputs. Request log entries
+// logged afterwards are dropped. It must be called on the logger returned by
+// NewLogService.
+func (l *LogService) Shutdown(ctx context.Context) error {
+	if err := l.pipeline.close(ctx); err != nil {
+		return err
+	}
+	var errs []error
+	for _, output := range l.outputs {
+		if err := output.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,186 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/natefinch/lumberjack"
+	"github.com/sirupsen/logrus"
+)
+
+// Output types
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+	OutputFile   = "file"
+	OutputSyslog = "syslog"
+)
+
+const (
+	defaultFilePath   = "logs/api-gateway.log"
+	defaultMaxSizeMB  = 10
+	defaultMaxAgeDays = 28
+	defaultMaxBackups = 3
+)
+
+// OutputConfig configures one destination of the logs
+type OutputConfig struct {
+	Type               string `yaml:"type" json:"type" toml:"type"`                                                                         // stdout, stderr, file or syslog
+	Level              string `yaml:"level,omitempty" json:"level,omitempty" toml:"level,omitempty"`                                        // Minimum level written, the logging level when empty
+	Format             string `yaml:"format,omitempty" json:"format,omitempty" toml:"format,omitempty"`                                     // json or text, the logging format when empty
+	Path               string `yaml:"path,omitempty" json:"path,omitempty" toml:"path,omitempty"`                                           // File written, logs/api-gateway.log when empty, or the syslog socket, /dev/log when empty
+	Tag                string `yaml:"tag,omitempty" json:"tag,omitempty" toml:"tag,omitempty"`                                              // Syslog tag, "api-gateway" when empty
+	MaxSizeMB          int    `yaml:"maxSizeMB,omitempty" json:"maxSizeMB,omitempty" toml:"maxSizeMB,omitzero"`                             // Size at which the file is rotated, 10 when zero
+	MaxAgeDays         int    `yaml:"maxAgeDays,omitempty" json:"maxAgeDays,omitempty" toml:"maxAgeDays,omitzero"`                          // Days rotated files are kept, 28 when zero
+	MaxBackups         int    `yaml:"maxBackups,omitempty" json:"maxBackups,omitempty" toml:"maxBackups,omitzero"`                          // Rotated files kept, 3 when zero
+	DisableCompression bool   `yaml:"disableCompression,omitempty" json:"disableCompression,omitempty" toml:"disableCompression,omitempty"` // Keep rotated files uncompressed
+}
+
+// Validate checks the type, level and format of the output
+func (c OutputConfig) Validate() error {
+	var errs []error
+	switch c.Type {
+	case OutputStdout, OutputStderr, OutputFile, OutputSyslog:
+	case "":
+		errs = append(errs, errors.New("type is required"))
+	default:
+		errs = append(errs, fmt.Errorf("unsupported type %q", c.Type))
+	}
+	if err := validateLevelAndFormat(c.Level, c.Format); err != nil {
+		errs = append(errs, err)
+	}
+	if c.MaxSizeMB < 0 || c.MaxAgeDays < 0 || c.MaxBackups < 0 {
+		errs = append(errs, errors.New("rotation limits must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// defaultOutputs are used when none are configured
+var defaultOutputs = []OutputConfig{
+	{Type: OutputStdout},
+	{Type: OutputFile},
+}
+
+// output is a logrus hook writing the entries of its levels to one
+// destination in its own format
+type output struct {
+	level     logrus.Level
+	formatter logrus.Formatter
+	mu        sync.Mutex
+	writer    io.Writer
+}
+
+var _ logrus.Hook = (*output)(nil)
+
+// newOutput creates the output described by cfg. Its level and format
+// default to those of the logging configuration.
+func newOutput(cfg OutputConfig, config LoggingConfig) *output {
+	levelName := cfg.Level
+	if levelName == "" {
+		levelName = config.Level
+	}
+	level, err := logrus.ParseLevel(levelName)
+	if err != nil {
+		level = logrus.InfoLevel // Default to Info level if parsing fails
+	}
+
+	format := cfg.Format
+	if format == "" {
+		format = config.Format
+	}
+
+	var writer io.Writer
+	switch cfg.Type {
+	case OutputStderr:
+		writer = os.Stderr
+	case OutputFile:
+		writer = newFileWriter(cfg)
+	case OutputSyslog:
+		writer = newSyslogWriter(cfg.Path, cfg.Tag)
+	default:
+		writer = os.Stdout
+	}
+	return &output{level: level, formatter: newFormatter(format), writer: writer}
+}
+
+func newFormatter(format string) logrus.Formatter {
+	if format == FormatJSON {
+		return &logrus.JSONFormatter{}
+	}
+	return &logrus.TextFormatter{FullTimestamp: true}
+}
+
+// newFileWriter returns a writer rotating the file of cfg
+func newFileWriter(cfg OutputConfig) *lumberjack.Logger {
+	writer := &lumberjack.Logger{
+		Filename:   cfg.Path,
+		MaxSize:    cfg.MaxSizeMB,
+		MaxAge:     cfg.MaxAgeDays,
+		MaxBackups: cfg.MaxBackups,
+		Compress:   !cfg.DisableCompression,
+	}
+	if writer.Filename == "" {
+		writer.Filename = defaultFilePath
+	}
+	if writer.MaxSize == 0 {
+		writer.MaxSize = defaultMaxSizeMB
+	}
+	if writer.MaxAge == 0 {
+		writer.MaxAge = defaultMaxAgeDays
+	}
+	if writer.MaxBackups == 0 {
+		writer.MaxBackups = defaultMaxBackups
+	}
+	return writer
+}
+
+// Levels returns the levels at least as severe as the output's level
+func (o *output) Levels() []logrus.Level {
+	return logrus.AllLevels[:o.level+1]
+}
+
+// Fire writes the entry
+func (o *output) Fire(entry *logrus.Entry) error {
+	line, err := o.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	return o.writeLine(entry.Level, line)
+}
+
+// writeLine writes a formatted entry of the given level
+func (o *output) writeLine(level logrus.Level, line []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var err error
+	if writer, ok := o.writer.(*syslogWriter); ok {
+		_, err = writer.WriteLevel(level, line)
+		return err
+	}
+	_, err = o.writer.Write(line)
+	return err
+}
+
+// Close releases the file or connection of the output
+func (o *output) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch writer := o.writer.(type) {
+	case *lumberjack.Logger:
+		return writer.Close()
+	case *syslogWriter:
+		return writer.Close()
+	}
+	return nil
+}
+
+// discardFormatter skips formatting entries for the logger's own output,
+// which is unused: every entry is written by the output hooks
+type discardFormatter struct{}
+
+func (discardFormatter) Format(*logrus.Entry) ([]byte, error) {
+	return nil, nil
+}
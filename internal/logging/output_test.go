@@ -0,0 +1,141 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggingConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config LoggingConfig
+		errors []string
+	}{
+		{name: "empty"},
+		{name: "valid", config: LoggingConfig{Level: "debug", Format: FormatText, Outputs: []OutputConfig{
+			{Type: OutputStdout, Level: "warn", Format: FormatJSON},
+			{Type: OutputSyslog},
+		}}},
+		{name: "invalid level and format", config: LoggingConfig{Level: "verbose", Format: "xml"}, errors: []string{
+			`unsupported level "verbose"`, `unsupported format "xml"`,
+		}},
+		{name: "negative batching", config: LoggingConfig{BufferSize: -1, BatchSize: -1, FlushInterval: -1}, errors: []string{
+			"bufferSize -1 must not be negative", "batchSize -1 must not be negative", "flushInterval -1ns must not be negative",
+		}},
+		{name: "invalid backpressure", config: LoggingConfig{Backpressure: "retry", BlockTimeout: -1}, errors: []string{
+			`unsupported backpressure policy "retry"`, "blockTimeout -1ns must not be negative",
+		}},
+		{name: "invalid outputs", config: LoggingConfig{Outputs: []OutputConfig{
+			{},
+			{Type: "kafka"},
+			{Type: OutputFile, Level: "loud", MaxAgeDays: -1},
+		}}, errors: []string{
+			"outputs[0]: type is required",
+			`outputs[1]: unsupported type "kafka"`,
+			`outputs[2]: unsupported level "loud"`,
+			"rotation limits must not be negative",
+		}},
+		{name: "invalid redaction", config: LoggingConfig{Redaction: RedactionConfig{Patterns: []string{"("}}}, errors: []string{
+			"redaction: patterns: error parsing regexp",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if len(tt.errors) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			for _, message := range tt.errors {
+				assert.Contains(t, err.Error(), message)
+			}
+		})
+	}
+}
+
+func TestLogService_Outputs(t *testing.T) {
+	dir := t.TempDir()
+	debugPath := filepath.Join(dir, "debug.log")
+	warnPath := filepath.Join(dir, "warn.log")
+	logService := NewLogService(LoggingConfig{Level: "info", Format: FormatJSON, Outputs: []OutputConfig{
+		{Type: OutputFile, Path: debugPath, Level: "debug"},
+		{Type: OutputFile, Path: warnPath, Level: "warn", Format: FormatText},
+	}})
+	defer logService.Shutdown(context.Background())
+
+	// The logger passes on what the most verbose output wants
+	assert.Equal(t, logrus.DebugLevel, logService.logger.GetLevel())
+
+	logService.Debug("details")
+	logService.Warn("careful")
+
+	entries := readEntries(t, debugPath)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "details", entries[0]["msg"])
+	assert.Equal(t, "careful", entries[1]["msg"])
+
+	text, err := os.ReadFile(warnPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(text), "details")
+	assert.Contains(t, string(text), `level=warning msg=careful`)
+}
+
+func TestNewFileWriter(t *testing.T) {
+	writer := newFileWriter(OutputConfig{Type: OutputFile})
+	assert.Equal(t, defaultFilePath, writer.Filename)
+	assert.Equal(t, defaultMaxSizeMB, writer.MaxSize)
+	assert.Equal(t, defaultMaxAgeDays, writer.MaxAge)
+	assert.Equal(t, defaultMaxBackups, writer.MaxBackups)
+	assert.True(t, writer.Compress)
+
+	writer = newFileWriter(OutputConfig{Type: OutputFile, Path: "gateway.log", MaxSizeMB: 50, MaxAgeDays: 7, MaxBackups: 10, DisableCompression: true})
+	assert.Equal(t, "gateway.log", writer.Filename)
+	assert.Equal(t, 50, writer.MaxSize)
+	assert.Equal(t, 7, writer.MaxAge)
+	assert.Equal(t, 10, writer.MaxBackups)
+	assert.False(t, writer.Compress)
+}
+
+func TestLogService_Syslog(t *testing.T) {
+	// Unix socket paths are limited to about 100 bytes, too few for t.TempDir
+	dir, err := os.MkdirTemp("", "syslog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+	conn, err := net.ListenPacket("unixgram", path)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	logService := NewLogService(LoggingConfig{Level: "info", Format: FormatJSON, Outputs: []OutputConfig{
+		{Type: OutputSyslog, Path: path, Tag: "gateway"},
+	}})
+	defer logService.Shutdown(context.Background())
+	logService.Errorf("upstream %s failed", "users")
+
+	buf := make([]byte, 4096)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	message := string(buf[:n])
+
+	// daemon facility (3) and error severity (3)
+	assert.Regexp(t, `^<27>\w{3} [ \d]\d \d\d:\d\d:\d\d `, message)
+	assert.Contains(t, message, fmt.Sprintf(" gateway[%d]: {", os.Getpid()))
+	assert.Contains(t, message, `"msg":"upstream users failed"`)
+}
+
+func TestSyslogWriter_Unavailable(t *testing.T) {
+	writer := newSyslogWriter(filepath.Join(t.TempDir(), "missing"), "")
+	_, err := writer.Write([]byte("lost\n"))
+	assert.ErrorContains(t, err, "connecting to syslog")
+}
@@ -0,0 +1,176 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/leo-andrei/api-gateway/internal/metrics"
+)
+
+// Backpressure policies, applied when the request log buffer is full
+const (
+	BackpressureDropNewest = "drop-newest"
+	BackpressureDropOldest = "drop-oldest"
+	BackpressureBlock      = "block"
+)
+
+// Reasons for dropping request log entries
+const (
+	dropBufferFull = "buffer_full"
+	dropTimeout    = "timeout"
+	dropShutdown   = "shutdown"
+)
+
+const (
+	defaultBufferSize    = 1000
+	defaultBatchSize     = 5
+	defaultFlushInterval = 5 * time.Second
+	defaultBlockTimeout  = time.Second
+)
+
+// pipeline queues request log entries and writes them in batches from its
+// own goroutine, so requests never wait for the outputs
+type pipeline struct {
+	entries       chan LogEntry
+	backpressure  string
+	blockTimeout  time.Duration
+	batchSize     int
+	flushInterval time.Duration
+	write         func([]LogEntry)
+	metrics       metrics.Metrics
+
+	// Senders hold a read lock so the channel is never closed under them.
+	// Closing stopping first makes blocked senders give up the lock.
+	mu       sync.RWMutex
+	closed   bool
+	stopping chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newPipeline(config LoggingConfig, write func([]LogEntry), metrics metrics.Metrics) *pipeline {
+	bufferSize := config.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	p := &pipeline{
+		entries:       make(chan LogEntry, bufferSize),
+		backpressure:  config.Backpressure,
+		blockTimeout:  config.BlockTimeout.Or(defaultBlockTimeout),
+		batchSize:     batchSize,
+		flushInterval: config.FlushInterval.Or(defaultFlushInterval),
+		write:         write,
+		metrics:       metrics,
+		stopping:      make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// send queues entry, applying the backpressure policy when the buffer is full
+func (p *pipeline) send(entry LogEntry) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		p.drop(dropShutdown)
+		return
+	}
+
+	select {
+	case p.entries <- entry:
+		return
+	default:
+	}
+
+	switch p.backpressure {
+	case BackpressureDropOldest:
+		for {
+			select {
+			case <-p.entries:
+				p.drop(dropBufferFull)
+			default:
+			}
+			select {
+			case p.entries <- entry:
+				return
+			default:
+			}
+		}
+	case BackpressureBlock:
+		timer := time.NewTimer(p.blockTimeout)
+		defer timer.Stop()
+		select {
+		case p.entries <- entry:
+		case <-timer.C:
+			p.drop(dropTimeout)
+		case <-p.stopping:
+			p.drop(dropShutdown)
+		}
+	default:
+		p.drop(dropBufferFull)
+	}
+}
+
+func (p *pipeline) drop(reason string) {
+	if p.metrics != nil {
+		p.metrics.IncrementLogEntriesDropped(reason)
+	}
+}
+
+// run writes the queued entries whenever a batch is full or the flush
+// interval passes, until the pipeline is closed and drained
+func (p *pipeline) run() {
+	defer close(p.done)
+	batch := make([]LogEntry, 0, p.batchSize)
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry, ok := <-p.entries:
+			if !ok {
+				// Channel is closed, flush remaining logs and exit
+				if len(batch) > 0 {
+					p.write(batch)
+				}
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= p.batchSize {
+				p.write(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.write(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// close stops accepting entries and waits until the queued ones are written
+// or ctx is done. Entries sent afterwards, or still waiting for room in the
+// buffer, are dropped.
+func (p *pipeline) close(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stopping) })
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.entries)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("flushing request logs: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,222 @@
+package logging
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/leo-andrei/api-gateway/internal/duration"
+	"github.com/leo-andrei/api-gateway/internal/metrics"
+)
+
+// recordingWriter keeps the batches written by a pipeline. While held, the
+// writer blocks, keeping the pipeline's goroutine busy with the first batch.
+type recordingWriter struct {
+	mu      sync.Mutex
+	batches [][]string
+	writing chan struct{}
+	release chan struct{}
+}
+
+func newRecordingWriter(held bool) *recordingWriter {
+	w := &recordingWriter{writing: make(chan struct{}, 100), release: make(chan struct{})}
+	if !held {
+		close(w.release)
+	}
+	return w
+}
+
+func (w *recordingWriter) write(batch []LogEntry) {
+	var messages []string
+	for _, entry := range batch {
+		messages = append(messages, entry.Message)
+	}
+	w.mu.Lock()
+	w.batches = append(w.batches, messages)
+	w.mu.Unlock()
+	select {
+	case w.writing <- struct{}{}:
+	default:
+	}
+	<-w.release
+}
+
+func (w *recordingWriter) Batches() [][]string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([][]string(nil), w.batches...)
+}
+
+func TestPipeline_BatchSize(t *testing.T) {
+	writer := newRecordingWriter(false)
+	p := newPipeline(LoggingConfig{BatchSize: 3, FlushInterval: duration.Duration(time.Hour)}, writer.write, nil)
+
+	for _, message := range []string{"1", "2", "3", "4"} {
+		p.send(LogEntry{Message: message})
+	}
+	<-writer.writing
+	assert.Equal(t, [][]string{{"1", "2", "3"}}, writer.Batches())
+
+	require.NoError(t, p.close(context.Background()))
+	assert.Equal(t, [][]string{{"1", "2", "3"}, {"4"}}, writer.Batches())
+}
+
+func TestPipeline_FlushInterval(t *testing.T) {
+	writer := newRecordingWriter(false)
+	p := newPipeline(LoggingConfig{BatchSize: 10, FlushInterval: duration.Duration(10 * time.Millisecond)}, writer.write, nil)
+	defer p.close(context.Background())
+
+	p.send(LogEntry{Message: "1"})
+	select {
+	case <-writer.writing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("partial batch not flushed")
+	}
+	assert.Equal(t, [][]string{{"1"}}, writer.Batches())
+}
+
+func TestPipeline_Backpressure(t *testing.T) {
+	tests := []struct {
+		backpressure string
+		written      []string
+		reason       string
+	}{
+		{backpressure: "", written: []string{"1", "2"}, reason: dropBufferFull},
+		{backpressure: BackpressureDropNewest, written: []string{"1", "2"}, reason: dropBufferFull},
+		{backpressure: BackpressureDropOldest, written: []string{"1", "3"}, reason: dropBufferFull},
+		{backpressure: BackpressureBlock, written: []string{"1", "2"}, reason: dropTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backpressure, func(t *testing.T) {
+			metricsService := metrics.NewMetricsService()
+			writer := newRecordingWriter(true)
+			p := newPipeline(LoggingConfig{
+				BufferSize:   1,
+				BatchSize:    1,
+				Backpressure: tt.backpressure,
+				BlockTimeout: duration.Duration(10 * time.Millisecond),
+			}, writer.write, metricsService)
+
+			// The first entry holds the writer, the second fills the buffer
+			p.send(LogEntry{Message: "1"})
+			<-writer.writing
+			p.send(LogEntry{Message: "2"})
+			p.send(LogEntry{Message: "3"})
+			assert.Equal(t, 1.0, testutil.ToFloat64(metricsService.LogEntriesDropped.WithLabelValues(tt.reason)))
+
+			close(writer.release)
+			require.NoError(t, p.close(context.Background()))
+			var written []string
+			for _, batch := range writer.Batches() {
+				written = append(written, batch...)
+			}
+			assert.Equal(t, tt.written, written)
+		})
+	}
+}
+
+func TestPipeline_BlockWaitsForRoom(t *testing.T) {
+	metricsService := metrics.NewMetricsService()
+	writer := newRecordingWriter(true)
+	p := newPipeline(LoggingConfig{
+		BufferSize:   1,
+		BatchSize:    1,
+		Backpressure: BackpressureBlock,
+		BlockTimeout: duration.Duration(time.Minute),
+	}, writer.write, metricsService)
+
+	p.send(LogEntry{Message: "1"})
+	<-writer.writing
+	p.send(LogEntry{Message: "2"})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(writer.release)
+	}()
+	p.send(LogEntry{Message: "3"})
+
+	require.NoError(t, p.close(context.Background()))
+	assert.Equal(t, [][]string{{"1"}, {"2"}, {"3"}}, writer.Batches())
+	assert.Equal(t, 0, testutil.CollectAndCount(metricsService.LogEntriesDropped))
+}
+
+func TestPipeline_Close(t *testing.T) {
+	metricsService := metrics.NewMetricsService()
+	writer := newRecordingWriter(false)
+	p := newPipeline(LoggingConfig{BatchSize: 100, FlushInterval: duration.Duration(time.Hour)}, writer.write, metricsService)
+
+	p.send(LogEntry{Message: "1"})
+	p.send(LogEntry{Message: "2"})
+	require.NoError(t, p.close(context.Background()))
+	assert.Equal(t, [][]string{{"1", "2"}}, writer.Batches())
+
+	// Entries logged after shutdown are counted, not sent on the closed channel
+	assert.NotPanics(t, func() { p.send(LogEntry{Message: "3"}) })
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricsService.LogEntriesDropped.WithLabelValues(dropShutdown)))
+	assert.NoError(t, p.close(context.Background()))
+}
+
+func TestPipeline_CloseTimeout(t *testing.T) {
+	writer := newRecordingWriter(true)
+	defer close(writer.release)
+	p := newPipeline(LoggingConfig{BatchSize: 1}, writer.write, nil)
+
+	p.send(LogEntry{Message: "1"})
+	<-writer.writing
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, p.close(ctx), context.DeadlineExceeded)
+}
+
+func TestPipeline_CloseReleasesBlockedSenders(t *testing.T) {
+	metricsService := metrics.NewMetricsService()
+	writer := newRecordingWriter(true)
+	defer close(writer.release)
+	p := newPipeline(LoggingConfig{
+		BufferSize:   1,
+		BatchSize:    1,
+		Backpressure: BackpressureBlock,
+		BlockTimeout: duration.Duration(time.Minute),
+	}, writer.write, metricsService)
+
+	p.send(LogEntry{Message: "1"})
+	<-writer.writing
+	p.send(LogEntry{Message: "2"})
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		p.send(LogEntry{Message: "3"})
+	}()
+	time.Sleep(10 * time.Millisecond) // Let the sender block
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, p.close(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second, "close waited for the blocked sender")
+
+	<-sent
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricsService.LogEntriesDropped.WithLabelValues(dropShutdown)))
+}
+
+func TestPipeline_ConcurrentSendAndClose(t *testing.T) {
+	writer := newRecordingWriter(false)
+	p := newPipeline(LoggingConfig{BufferSize: 10}, writer.write, nil)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				p.send(LogEntry{Message: "entry"})
+			}
+		}()
+	}
+	assert.NoError(t, p.close(context.Background()))
+	wg.Wait()
+}
@@ -0,0 +1,367 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultReplacement = "[REDACTED]"
+
+// Always redacted, in addition to the configured rules
+var (
+	defaultRedactedHeaders     = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+	defaultRedactedQueryParams = []string{"access_token"}
+)
+
+// RedactionConfig selects the data replaced before anything is logged. The
+// Authorization, Proxy-Authorization, Cookie and Set-Cookie headers and the
+// access_token query parameter are always redacted.
+type RedactionConfig struct {
+	Headers      []string `yaml:"headers,omitempty" json:"headers,omitempty" toml:"headers,omitempty"`                // Header names, matched case-insensitively
+	QueryParams  []string `yaml:"queryParams,omitempty" json:"queryParams,omitempty" toml:"queryParams,omitempty"`    // Query parameter names, matched case-insensitively
+	BodyFields   []string `yaml:"bodyFields,omitempty" json:"bodyFields,omitempty" toml:"bodyFields,omitempty"`       // JSON body fields by dot path, such as user.password; * matches any key and arrays add no segment
+	BodyPatterns []string `yaml:"bodyPatterns,omitempty" json:"bodyPatterns,omitempty" toml:"bodyPatterns,omitempty"` // Regular expressions matching the names of JSON body fields at any depth, such as (?i)secret
+	Patterns     []string `yaml:"patterns,omitempty" json:"patterns,omitempty" toml:"patterns,omitempty"`             // Regular expressions replaced in every message, field and access log line, such as Bearer \S+
+	Replacement  string   `yaml:"replacement,omitempty" json:"replacement,omitempty" toml:"replacement,omitempty"`    // "[REDACTED]" when empty
+}
+
+// Validate checks the body field paths and the regular expressions
+func (c RedactionConfig) Validate() error {
+	var errs []error
+	for _, path := range c.BodyFields {
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			errs = append(errs, fmt.Errorf("bodyFields: invalid path %q", path))
+		}
+	}
+	for _, pattern := range c.BodyPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("bodyPatterns: %w", err))
+		}
+	}
+	for _, pattern := range c.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("patterns: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Redactor replaces sensitive headers, query parameters, JSON body fields
+// and patterns before they are logged. A LogService redacts every entry on
+// its way to the outputs, so handlers can log headers, URLs and JSON bodies
+// as fields; NewRedactor applies the same rules anywhere else.
+type Redactor struct {
+	headers      map[string]bool
+	queryParams  map[string]bool
+	bodyFields   [][]string
+	bodyPatterns []*regexp.Regexp
+	patterns     []*regexp.Regexp
+	urlQuery     *regexp.Regexp // Sensitive parameters in URLs within text
+	replacement  string
+}
+
+// NewRedactor returns a redactor applying the rules of cfg on top of the
+// defaults. Invalid expressions, rejected when the configuration is
+// validated, are skipped.
+func NewRedactor(cfg RedactionConfig) *Redactor {
+	r := &Redactor{
+		headers:     make(map[string]bool),
+		queryParams: make(map[string]bool),
+		replacement: cfg.Replacement,
+	}
+	if r.replacement == "" {
+		r.replacement = defaultReplacement
+	}
+	for _, name := range append(defaultRedactedHeaders, cfg.Headers...) {
+		r.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	var names []string
+	for _, name := range append(defaultRedactedQueryParams, cfg.QueryParams...) {
+		r.queryParams[strings.ToLower(name)] = true
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	r.urlQuery = regexp.MustCompile(`((?:^|[?&])(?i:` + strings.Join(names, "|") + `)=)[^&#\s"']*`)
+	for _, path := range cfg.BodyFields {
+		r.bodyFields = append(r.bodyFields, strings.Split(path, "."))
+	}
+	r.bodyPatterns = compilePatterns(cfg.BodyPatterns)
+	r.patterns = compilePatterns(cfg.Patterns)
+	return r
+}
+
+func compilePatterns(patterns []string) []*regexp.Regexp {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		if re, err := regexp.Compile(pattern); err == nil {
+			compiled = append(compiled, re)
+		}
+	}
+	return compiled
+}
+
+// String replaces the matches of the patterns, and sensitive parameters of
+// the URLs, in s
+func (r *Redactor) String(s string) string {
+	s = r.urlQuery.ReplaceAllString(s, "${1}"+strings.ReplaceAll(r.replacement, "$", "$$"))
+	for _, re := range r.patterns {
+		s = re.ReplaceAllLiteralString(s, r.replacement)
+	}
+	return s
+}
+
+// Header returns h with the values of sensitive headers replaced and the
+// query of the Referer redacted. h itself is returned when nothing changes.
+func (r *Redactor) Header(h http.Header) http.Header {
+	var redacted http.Header
+	for name, values := range h {
+		var replaced []string
+		switch {
+		case r.headers[http.CanonicalHeaderKey(name)]:
+			replaced = make([]string, len(values))
+			for i := range values {
+				replaced[i] = r.replacement
+			}
+		case http.CanonicalHeaderKey(name) == "Referer":
+			for i, value := range values {
+				if query := r.URL(value); query != value {
+					if replaced == nil {
+						replaced = append([]string(nil), values...)
+					}
+					replaced[i] = query
+				}
+			}
+		}
+		if replaced == nil {
+			continue
+		}
+		if redacted == nil {
+			redacted = h.Clone()
+		}
+		redacted[name] = replaced
+	}
+	if redacted == nil {
+		return h
+	}
+	return redacted
+}
+
+// Query returns rawQuery with the values of sensitive parameters replaced,
+// keeping the order and encoding of the others
+func (r *Redactor) Query(rawQuery string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+	params := strings.Split(rawQuery, "&")
+	changed := false
+	for i, param := range params {
+		key, _, hasValue := strings.Cut(param, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if hasValue && r.queryParams[strings.ToLower(name)] {
+			params[i] = key + "=" + r.replacement
+			changed = true
+		}
+	}
+	if !changed {
+		return rawQuery
+	}
+	return strings.Join(params, "&")
+}
+
+// URL returns the URL or request URI rawURL with its query redacted
+func (r *Redactor) URL(rawURL string) string {
+	rest, fragment, hasFragment := strings.Cut(rawURL, "#")
+	path, query, hasQuery := strings.Cut(rest, "?")
+	if !hasQuery {
+		return rawURL
+	}
+	redacted := path + "?" + r.Query(query)
+	if hasFragment {
+		redacted += "#" + fragment
+	}
+	return redacted
+}
+
+// JSON returns body with the values of sensitive fields replaced and the
+// patterns redacted in its strings. Bodies that are not JSON are returned
+// unchanged.
+func (r *Redactor) JSON(body []byte) []byte {
+	redacted, _ := r.redactBody(body)
+	return redacted
+}
+
+// redactBody redacts body when it holds a single JSON value, and reports
+// whether it does
+func (r *Redactor) redactBody(body []byte) ([]byte, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return body, false
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return body, false
+	}
+	value, changed := r.redactJSON(value, nil)
+	if !changed {
+		return body, true
+	}
+
+	var redacted bytes.Buffer
+	encoder := json.NewEncoder(&redacted)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return body, false
+	}
+	return bytes.TrimSuffix(redacted.Bytes(), []byte("\n")), true
+}
+
+// redactJSON returns value, found at path, with its sensitive fields
+// replaced, and reports whether anything was
+func (r *Redactor) redactJSON(value any, path []string) (any, bool) {
+	changed := false
+	switch value := value.(type) {
+	case string:
+		redacted := r.String(value)
+		return redacted, redacted != value
+	case map[string]any:
+		for key, child := range value {
+			childPath := append(path[:len(path):len(path)], key)
+			if r.sensitiveField(childPath) {
+				value[key] = r.replacement
+				changed = true
+			} else if child, ok := r.redactJSON(child, childPath); ok {
+				value[key] = child
+				changed = true
+			}
+		}
+	case []any:
+		for i, element := range value {
+			if element, ok := r.redactJSON(element, path); ok {
+				value[i] = element
+				changed = true
+			}
+		}
+	}
+	return value, changed
+}
+
+// sensitiveField reports whether the field at path matches a body field path
+// or its name matches a body pattern
+func (r *Redactor) sensitiveField(path []string) bool {
+	for _, fieldPath := range r.bodyFields {
+		if matchFieldPath(fieldPath, path) {
+			return true
+		}
+	}
+	name := path[len(path)-1]
+	for _, re := range r.bodyPatterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchFieldPath(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i, segment := range pattern {
+		if segment != "*" && segment != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// field redacts the value of a log entry field. Fields named after a
+// sensitive header, such as authorization or x_api_key, are replaced whole.
+// JSON bodies, logged as json.RawMessage, []byte or string, have their
+// sensitive fields replaced.
+func (r *Redactor) field(key string, value any) any {
+	if r.headers[http.CanonicalHeaderKey(strings.ReplaceAll(key, "_", "-"))] {
+		return r.replacement
+	}
+	switch value := value.(type) {
+	case string:
+		if looksLikeJSON(value) {
+			if body, ok := r.redactBody([]byte(value)); ok {
+				return string(body)
+			}
+		}
+		return r.String(value)
+	case []byte:
+		// Written as text rather than base64
+		return r.field(key, string(value))
+	case json.RawMessage:
+		if body, ok := r.redactBody(value); ok {
+			return json.RawMessage(body)
+		}
+		return r.String(string(value))
+	case error:
+		if message := r.String(value.Error()); message != value.Error() {
+			return message
+		}
+	case http.Header:
+		return r.Header(value)
+	case url.Values:
+		return r.Query(value.Encode())
+	case *url.URL:
+		if value != nil {
+			return r.String(r.URL(value.String()))
+		}
+	}
+	return value
+}
+
+func looksLikeJSON(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")
+}
+
+// request returns a shallow copy of req with its query and headers redacted,
+// for the access log
+func (r *Redactor) request(req *http.Request) *http.Request {
+	redacted := *req
+	if req.URL != nil {
+		u := *req.URL
+		u.RawQuery = r.Query(u.RawQuery)
+		redacted.URL = &u
+	}
+	redacted.Header = r.Header(req.Header)
+	return &redacted
+}
+
+// redactionHook redacts the message and fields of every entry before the
+// output hooks write it
+type redactionHook struct {
+	redactor *Redactor
+}
+
+var _ logrus.Hook = redactionHook{}
+
+// Levels returns every level, debug included
+func (redactionHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire redacts the entry in place
+func (h redactionHook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.redactor.String(entry.Message)
+	for key, value := range entry.Data {
+		entry.Data[key] = h.redactor.field(key, value)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactor_Header(t *testing.T) {
+	redactor := NewRedactor(RedactionConfig{Headers: []string{"x-api-key"}})
+	header := http.Header{
+		"Authorization": {"Bearer secret"},
+		"Cookie":        {"session=1", "theme=dark"},
+		"X-Api-Key":     {"k3y"},
+		"Referer":       {"https://example.com/cb?code=1&access_token=t0k#top"},
+		"Accept":        {"application/json"},
+	}
+
+	assert.Equal(t, http.Header{
+		"Authorization": {"[REDACTED]"},
+		"Cookie":        {"[REDACTED]", "[REDACTED]"},
+		"X-Api-Key":     {"[REDACTED]"},
+		"Referer":       {"https://example.com/cb?code=1&access_token=[REDACTED]#top"},
+		"Accept":        {"application/json"},
+	}, redactor.Header(header))
+	assert.Equal(t, "Bearer secret", header.Get("Authorization"), "the original header is left alone")
+
+	plain := http.Header{"Accept": {"*/*"}}
+	assert.Equal(t, plain, redactor.Header(plain))
+}
+
+func TestRedactor_Query(t *testing.T) {
+	redactor := NewRedactor(RedactionConfig{QueryParams: []string{"api_key", "Sig"}})
+
+	assert.Equal(t, "b=2&access_token=[REDACTED]&a=%20x", redactor.Query("b=2&access_token=abc&a=%20x"))
+	assert.Equal(t, "API_KEY=[REDACTED]&sig=[REDACTED]&sig", redactor.Query("API_KEY=1&sig=2&sig"))
+	assert.Equal(t, "api%5Fkey=[REDACTED]", redactor.Query("api%5Fkey=1"))
+	assert.Equal(t, "q=token", redactor.Query("q=token"))
+	assert.Equal(t, "/users?api_key=[REDACTED]#frag", redactor.URL("/users?api_key=1#frag"))
+	assert.Equal(t, "/users", redactor.URL("/users"))
+}
+
+func TestRedactor_JSON(t *testing.T) {
+	redactor := NewRedactor(RedactionConfig{
+		BodyFields:   []string{"user.password", "cards.number", "*.pin"},
+		BodyPatterns: []string{`(?i)secret`},
+		Replacement:  "***",
+	})
+
+	body := `{"user":{"name":"alice","password":"hunter2"},"cards":[{"number":"4111","exp":"12/30"},{"number":"5500"}],` +
+		`"account":{"pin":1234},"password":"top-level","ClientSecret":"s","note":"<b>","amount":12.50}`
+	assert.JSONEq(t, `{"user":{"name":"alice","password":"***"},"cards":[{"number":"***","exp":"12/30"},{"number":"***"}],`+
+		`"account":{"pin":"***"},"password":"top-level","ClientSecret":"***","note":"<b>","amount":12.50}`, string(redactor.JSON([]byte(body))))
+	assert.Contains(t, string(redactor.JSON([]byte(body))), `"amount":12.50`, "numbers are kept as written")
+
+	for _, body := range []string{`{"user":{"name":"alice"}}`, `not json`, `{"password":1} trailing`, ``} {
+		assert.Equal(t, body, string(redactor.JSON([]byte(body))))
+	}
+}
+
+func TestRedactor_JSONPatterns(t *testing.T) {
+	redactor := NewRedactor(RedactionConfig{Patterns: []string{`Bearer \S+`}})
+
+	assert.JSONEq(t, `{"auth":"[REDACTED]","next":"/page?access_token=[REDACTED]","n":1}`,
+		string(redactor.JSON([]byte(`{"auth":"Bearer abc\"def","next":"/page?access_token=t","n":1}`))))
+}
+
+func TestRedactor_String(t *testing.T) {
+	redactor := NewRedactor(RedactionConfig{Patterns: []string{`Bearer \S+`, `\b\d{16}\b`}, Replacement: "$1"})
+
+	assert.Equal(t, "calling http://users/?page=2&access_token=$1 with $1 for card $1",
+		redactor.String("calling http://users/?page=2&access_token=abc with Bearer eyJ.x.y for card 4111111111111111"))
+	assert.Equal(t, "access_token=$1", redactor.String("access_token=abc"))
+}
+
+func TestRedactionConfig_Validate(t *testing.T) {
+	assert.NoError(t, RedactionConfig{BodyFields: []string{"user.password", "*.token"}, BodyPatterns: []string{"(?i)secret"}, Patterns: []string{`Bearer \S+`}}.Validate())
+
+	err := RedactionConfig{BodyFields: []string{"user..password", ""}, BodyPatterns: []string{"("}, Patterns: []string{"[a-"}}.Validate()
+	assert.EqualError(t, err, `bodyFields: invalid path "user..password"`+"\n"+
+		`bodyFields: invalid path ""`+"\n"+
+		"bodyPatterns: error parsing regexp: missing closing ): `(`\n"+
+		"patterns: error parsing regexp: missing closing ]: `[a-`")
+}
+
+func TestLogService_RedactsEntries(t *testing.T) {
+	logService, read := newFileLogService(t, LoggingConfig{
+		Level:     "debug",
+		Format:    FormatJSON,
+		Redaction: RedactionConfig{Headers: []string{"X-Api-Key"}, Patterns: []string{`Bearer \S+`}},
+	})
+
+	logService.With(Fields{"x_api_key": "k3y", "header": http.Header{"Cookie": {"session=1"}}}).
+		Debugf("Forwarding with Authorization: Bearer %s", "eyJ.x.y")
+	logService.With(Fields{"error": errors.New(`Get "http://users/?access_token=abc": EOF`)}).Error("Upstream failed")
+
+	entries := read()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "Forwarding with Authorization: [REDACTED]", entries[0]["msg"])
+	assert.Equal(t, "[REDACTED]", entries[0]["x_api_key"])
+	assert.Equal(t, map[string]any{"Cookie": []any{"[REDACTED]"}}, entries[0]["header"])
+	assert.Equal(t, `Get "http://users/?access_token=[REDACTED]": EOF`, entries[1]["error"])
+}
+
+func TestLogService_RedactsBodies(t *testing.T) {
+	logService, read := newFileLogService(t, LoggingConfig{
+		Level:  "debug",
+		Format: FormatJSON,
+		Redaction: RedactionConfig{
+			BodyFields:   []string{"user.pin"},
+			BodyPatterns: []string{`(?i)password`},
+		},
+	})
+
+	logService.With(Fields{
+		"request_body":  []byte(`{"user":{"name":"alice","pin":"1234"},"password":"hunter2"}`),
+		"upstream_body": json.RawMessage(`[{"user":{"pin":"9876"}}]`),
+		"body":          `{"NewPassword":"s3cret"}`,
+		"text":          `{not json, password: x}`,
+	}).Debug("Forwarding request")
+
+	entries := read()
+	require.Len(t, entries, 1)
+	assert.JSONEq(t, `{"user":{"name":"alice","pin":"[REDACTED]"},"password":"[REDACTED]"}`, entries[0]["request_body"].(string))
+	assert.Equal(t, []any{map[string]any{"user": map[string]any{"pin": "[REDACTED]"}}}, entries[0]["upstream_body"])
+	assert.Equal(t, `{"NewPassword":"[REDACTED]"}`, entries[0]["body"])
+	assert.Equal(t, `{not json, password: x}`, entries[0]["text"])
+}
+
+func TestLogService_RedactsAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	logService := NewLogService(LoggingConfig{
+		Outputs: []OutputConfig{{Type: OutputFile, Path: path}},
+		AccessLog: AccessLogConfig{
+			Template: `%{uri} %{query} %{request_header:Authorization} %{request_header:X-Trace} %{response_header:Set-Cookie} %{referer}`,
+		},
+		Redaction: RedactionConfig{QueryParams: []string{"key"}, Patterns: []string{`trace-\d+`}},
+	})
+
+	r := httptest.NewRequest("GET", "/users?key=1&page=2", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("X-Trace", "trace-42")
+	r.Header.Set("Referer", "https://example.com/?access_token=abc")
+	ctx, access := NewAccessContext(r.Context())
+	access.ResponseHeader = http.Header{"Set-Cookie": {"session=1"}}
+	logService.LogRequest(r.WithContext(ctx), time.Millisecond, http.StatusOK, 0)
+	require.NoError(t, logService.Shutdown(context.Background()))
+
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "/users?key=[REDACTED]&page=2 key=[REDACTED]&page=2 [REDACTED] [REDACTED] [REDACTED] https://example.com/?access_token=[REDACTED]\n", string(written))
+	assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"), "the request is left alone")
+	assert.Equal(t, "key=1&page=2", r.URL.RawQuery)
+}
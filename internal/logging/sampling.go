@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SamplingRule logs a fraction of the requests it matches. A rule matches
+// requests to its route with a status in its class; empty fields match
+// anything.
+type SamplingRule struct {
+	Route  string  `yaml:"route,omitempty" json:"route,omitempty" toml:"route,omitempty"`    // Route name
+	Status string  `yaml:"status,omitempty" json:"status,omitempty" toml:"status,omitempty"` // Status class such as 2xx, or a status code such as 404
+	Rate   float64 `yaml:"rate" json:"rate" toml:"rate"`                                     // Fraction of matching requests logged, from 0 to 1
+}
+
+// Validate checks the status and rate of the rule
+func (r SamplingRule) Validate() error {
+	var errs []error
+	if _, _, err := parseStatusClass(r.Status); err != nil {
+		errs = append(errs, err)
+	}
+	if r.Rate < 0 || r.Rate > 1 {
+		errs = append(errs, fmt.Errorf("rate %v must be between 0 and 1", r.Rate))
+	}
+	return errors.Join(errs...)
+}
+
+// parseStatusClass returns the range of statuses matched by a status class
+// (2xx) or code (404). An empty status matches every code.
+func parseStatusClass(status string) (low, high int, err error) {
+	if status == "" {
+		return 0, 999, nil
+	}
+	if len(status) == 3 && strings.EqualFold(status[1:], "xx") && status[0] >= '1' && status[0] <= '5' {
+		low = int(status[0]-'0') * 100
+		return low, low + 99, nil
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 100 || code > 599 {
+		return 0, 0, fmt.Errorf("invalid status %q, expected a class such as 2xx or a code such as 404", status)
+	}
+	return code, code, nil
+}
+
+// accessFilter decides which requests are written to the access log. Every
+// request is still measured, as metrics are recorded before LogRequest.
+type accessFilter struct {
+	exact         map[string]bool
+	prefixes      []string
+	rules         []samplingRule
+	slowThreshold time.Duration
+	random        func() float64
+}
+
+type samplingRule struct {
+	SamplingRule
+	low, high int
+}
+
+func newAccessFilter(cfg AccessLogConfig) *accessFilter {
+	f := &accessFilter{
+		exact:         make(map[string]bool),
+		slowThreshold: cfg.SlowThreshold.Std(),
+		random:        rand.Float64,
+	}
+	for _, pattern := range cfg.Exclude {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			f.prefixes = append(f.prefixes, prefix)
+		} else {
+			f.exact[pattern] = true
+		}
+	}
+	for _, rule := range cfg.Sampling {
+		// Rules are validated with the configuration
+		low, high, _ := parseStatusClass(rule.Status)
+		f.rules = append(f.rules, samplingRule{SamplingRule: rule, low: low, high: high})
+	}
+	return f
+}
+
+// keep reports whether rec is logged: excluded paths never are, slow requests
+// always are, and the rest are sampled by the first rule they match
+func (f *accessFilter) keep(rec *accessRecord) bool {
+	path := rec.request.URL.Path
+	if f.exact[path] {
+		return false
+	}
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	if f.slowThreshold > 0 && rec.duration >= f.slowThreshold {
+		return true
+	}
+	for _, rule := range f.rules {
+		if rule.Route != "" && rule.Route != rec.access.Route {
+			continue
+		}
+		if rec.status < rule.low || rec.status > rule.high {
+			continue
+		}
+		return rule.Rate >= 1 || f.random() < rule.Rate
+	}
+	return true
+}
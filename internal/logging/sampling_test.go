@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/leo-andrei/api-gateway/internal/duration"
+)
+
+func TestSamplingRule_Validate(t *testing.T) {
+	for _, rule := range []SamplingRule{
+		{Rate: 0},
+		{Status: "2xx", Rate: 0.01},
+		{Status: "5XX", Rate: 1},
+		{Route: "get-users", Status: "404", Rate: 0.5},
+	} {
+		assert.NoError(t, rule.Validate(), rule)
+	}
+
+	assert.EqualError(t, SamplingRule{Status: "2x", Rate: 1}.Validate(), `invalid status "2x", expected a class such as 2xx or a code such as 404`)
+	assert.EqualError(t, SamplingRule{Status: "6xx", Rate: 1}.Validate(), `invalid status "6xx", expected a class such as 2xx or a code such as 404`)
+	assert.EqualError(t, SamplingRule{Status: "700", Rate: 1}.Validate(), `invalid status "700", expected a class such as 2xx or a code such as 404`)
+	assert.EqualError(t, SamplingRule{Rate: 1.5}.Validate(), "rate 1.5 must be between 0 and 1")
+}
+
+func TestAccessFilter_Keep(t *testing.T) {
+	filter := newAccessFilter(AccessLogConfig{
+		Exclude: []string{"/health", "/internal/*"},
+		Sampling: []SamplingRule{
+			{Route: "get-orders", Status: "2xx", Rate: 0.9},
+			{Status: "2xx", Rate: 0.01},
+			{Status: "404", Rate: 0},
+			{Status: "5xx", Rate: 1},
+		},
+		SlowThreshold: duration.Duration(500 * time.Millisecond),
+	})
+	filter.random = func() float64 { return 0.5 }
+
+	tests := []struct {
+		name     string
+		path     string
+		route    string
+		status   int
+		duration time.Duration
+		keep     bool
+	}{
+		{name: "excluded path", path: "/health", status: 200, keep: false},
+		{name: "excluded even when slow", path: "/health", status: 200, duration: time.Second, keep: false},
+		{name: "excluded prefix", path: "/internal/stats", status: 500, keep: false},
+		{name: "exact exclusion only", path: "/healthz", status: 500, keep: true},
+		{name: "2xx sampled out", path: "/users", route: "get-users", status: 200, keep: false},
+		{name: "slow 2xx kept", path: "/users", route: "get-users", status: 200, duration: 500 * time.Millisecond, keep: true},
+		{name: "route rule first", path: "/orders", route: "get-orders", status: 200, keep: true},
+		{name: "route rule status", path: "/orders", route: "get-orders", status: 201, keep: true},
+		{name: "status code", path: "/missing", status: 404, keep: false},
+		{name: "5xx kept", path: "/users", route: "get-users", status: 503, keep: true},
+		{name: "no matching rule", path: "/users", route: "get-users", status: 401, keep: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := newAccessRecord(httptest.NewRequest("GET", tt.path, nil), tt.duration, tt.status, 0)
+			record.access.Route = tt.route
+			assert.Equal(t, tt.keep, filter.keep(record))
+		})
+	}
+}
+
+func TestAccessFilter_Rate(t *testing.T) {
+	filter := newAccessFilter(AccessLogConfig{Sampling: []SamplingRule{{Rate: 0.1}}})
+	record := newAccessRecord(httptest.NewRequest("GET", "/users", nil), time.Millisecond, 200, 0)
+
+	kept := 0
+	for range 10000 {
+		if filter.keep(record) {
+			kept++
+		}
+	}
+	assert.InDelta(t, 1000, kept, 200)
+}
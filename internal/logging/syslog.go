@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSyslogPath = "/dev/log"
+	defaultSyslogTag  = "api-gateway"
+
+	facilityDaemon = 3
+)
+
+// Syslog severities
+const (
+	severityCritical = 2
+	severityError    = 3
+	severityWarning  = 4
+	severityInfo     = 6
+	severityDebug    = 7
+)
+
+// syslogWriter sends entries to the local syslog daemon over its Unix
+// socket, in the format of log/syslog. It connects on first use and again
+// after a failed write, so the gateway starts even when syslog is not yet
+// running.
+type syslogWriter struct {
+	path string
+	tag  string
+	conn net.Conn
+}
+
+func newSyslogWriter(path, tag string) *syslogWriter {
+	if path == "" {
+		path = defaultSyslogPath
+	}
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+	return &syslogWriter{path: path, tag: tag}
+}
+
+// Write sends line at info severity
+func (w *syslogWriter) Write(line []byte) (int, error) {
+	return w.WriteLevel(logrus.InfoLevel, line)
+}
+
+// WriteLevel sends line with the severity of level
+func (w *syslogWriter) WriteLevel(level logrus.Level, line []byte) (int, error) {
+	message := fmt.Sprintf("<%d>%s %s[%d]: %s\n",
+		facilityDaemon*8+severity(level),
+		time.Now().Format(time.Stamp),
+		w.tag, os.Getpid(),
+		bytes.TrimRight(line, "\n"),
+	)
+
+	var err error
+	for range 2 {
+		if w.conn == nil {
+			if w.conn, err = dialSyslog(w.path); err != nil {
+				return 0, err
+			}
+		}
+		if _, err = w.conn.Write([]byte(message)); err == nil {
+			return len(line), nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	return 0, err
+}
+
+// Close closes the connection to syslog
+func (w *syslogWriter) Close() error {
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// dialSyslog connects to the syslog socket, which is a datagram socket on
+// most systems and a stream socket on some
+func dialSyslog(path string) (net.Conn, error) {
+	var err error
+	for _, network := range []string{"unixgram", "unix"} {
+		var conn net.Conn
+		if conn, err = net.Dial(network, path); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("connecting to syslog at %s: %w", path, err)
+}
+
+func severity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return severityCritical
+	case logrus.ErrorLevel:
+		return severityError
+	case logrus.WarnLevel:
+		return severityWarning
+	case logrus.InfoLevel:
+		return severityInfo
+	default:
+		return severityDebug
+	}
+}
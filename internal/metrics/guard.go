@@ -0,0 +1,41 @@
+package metrics
+
+import "sync"
+
+// OverflowValue replaces label values beyond the cardinality limit
+const OverflowValue = "other"
+
+const defaultMaxLabelValues = 1000
+
+// labelGuard caps the number of distinct values of a label. Values seen
+// before the cap was reached keep being reported; later ones are folded into
+// OverflowValue.
+type labelGuard struct {
+	mu       sync.Mutex
+	max      int
+	seen     map[string]struct{}
+	overflow func()
+}
+
+func newLabelGuard(max int, overflow func()) *labelGuard {
+	return &labelGuard{
+		max:      max,
+		seen:     make(map[string]struct{}),
+		overflow: overflow,
+	}
+}
+
+// value returns v, or OverflowValue when v would exceed the cap
+func (g *labelGuard) value(v string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.seen[v]; ok {
+		return v
+	}
+	if len(g.seen) >= g.max {
+		g.overflow()
+		return OverflowValue
+	}
+	g.seen[v] = struct{}{}
+	return v
+}
@@ -1,10 +1,79 @@
 package metrics
 
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MetricsConfig names the exported metrics
+type MetricsConfig struct {
+	Namespace string            `yaml:"namespace,omitempty" json:"namespace,omitempty" toml:"namespace,omitempty"` // Prefix of every metric name, "api_gateway" when empty
+	Subsystem string            `yaml:"subsystem,omitempty" json:"subsystem,omitempty" toml:"subsystem,omitempty"` // Added between the namespace and the metric name
+	Labels    map[string]string `yaml:"labels,omitempty" json:"labels,omitempty" toml:"labels,omitempty"`          // Constant labels added to every metric
+
+	UnmatchedPath  string `yaml:"unmatchedPath,omitempty" json:"unmatchedPath,omitempty" toml:"unmatchedPath,omitempty"`   // Path label of requests matching no route, "unmatched" when empty
+	MaxLabelValues int    `yaml:"maxLabelValues,omitempty" json:"maxLabelValues,omitempty" toml:"maxLabelValues,omitzero"` // Distinct values kept per route, path and upstream label, 1000 when zero
+}
+
+var metricName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// variableLabels are set per observation, so they cannot be constant labels
+var variableLabels = map[string]bool{
+	"method": true, "path": true, "status": true, "protocol": true, "host": true, "route": true, "label": true,
+	"upstream": true, "reused": true, "category": true, "reason": true,
+}
+
+// Validate checks that the namespace, subsystem and labels are valid
+// Prometheus names
+func (c MetricsConfig) Validate() error {
+	var errs []error
+	if c.Namespace != "" && !metricName.MatchString(c.Namespace) {
+		errs = append(errs, fmt.Errorf("invalid namespace %q", c.Namespace))
+	}
+	if c.Subsystem != "" && !metricName.MatchString(c.Subsystem) {
+		errs = append(errs, fmt.Errorf("invalid subsystem %q", c.Subsystem))
+	}
+	for name := range c.Labels {
+		switch {
+		case !metricName.MatchString(name) || strings.HasPrefix(name, "__"):
+			errs = append(errs, fmt.Errorf("invalid label name %q", name))
+		case variableLabels[name]:
+			errs = append(errs, fmt.Errorf("label %q is already set by the gateway", name))
+		}
+	}
+	if c.MaxLabelValues < 0 {
+		errs = append(errs, errors.New("maxLabelValues must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// Metrics records the gateway's measurements. Request metrics are labelled
+// with the name and path template of the matched route, never the raw path.
 type Metrics interface {
-	IncrementRequestCount(method, path, status string)
-	ObserveRequestDuration(method, path string, duration float64)
-	IncrementActiveConnections(method, path string)
-	DecrementActiveConnections(method, path string)
-	ObserveRequestSize(method, path string, size float64)
-	ObserveResponseSize(method, path string, size float64)
+	IncrementRequestCount(method, route, path, status string)
+	ObserveRequestDuration(method, route, path, protocol string, duration float64)
+	IncrementActiveConnections(method, route, path string)
+	DecrementActiveConnections(method, route, path string)
+	ObserveRequestSize(method, route, path string, size float64)
+	ObserveResponseSize(method, route, path string, size float64)
+	SetRequestsInFlight(count float64)
+	IncrementCertificateIssued(host string)
+	IncrementCertificateErrors(host string)
+	SetCertificateExpiry(host string, timestamp float64)
+
+	// Upstream metrics describe the gateway's requests to an upstream,
+	// identified by its host:port
+	ObserveUpstreamConnect(upstream string, duration float64)
+	ObserveUpstreamTLSHandshake(upstream string, duration float64)
+	ObserveUpstreamTimeToFirstByte(upstream string, duration float64)
+	IncrementUpstreamConnections(upstream string, reused bool)
+	IncrementUpstreamResponses(upstream, status string)
+	IncrementUpstreamErrors(upstream, category string)
+
+	// IncrementLogEntriesDropped counts request log entries that were never
+	// written: the buffer was full, a blocked write timed out, or logging had
+	// shut down
+	IncrementLogEntriesDropped(reason string)
 }
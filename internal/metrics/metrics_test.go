@@ -1,10 +1,14 @@
 package metrics
 
 import (
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewMetricsService(t *testing.T) {
@@ -16,7 +20,88 @@ func TestNewMetricsService(t *testing.T) {
 	assert.NotNil(t, metricsService.ResponseSize)
 	assert.NotNil(t, metricsService.ActiveConnections)
 
-	// Verify that metrics are registered
-	assert.NoError(t, testutil.CollectAndCompare(metricsService.RequestCount, nil))
-	assert.NoError(t, testutil.CollectAndCompare(metricsService.RequestDuration, nil))
+	// No series exist before the first observation
+	assert.Equal(t, 0, testutil.CollectAndCount(metricsService.RequestCount))
+	assert.Equal(t, 0, testutil.CollectAndCount(metricsService.RequestDuration))
+
+	metricsService.IncrementRequestCount("GET", "users", "/users/{id}", "200")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricsService.RequestCount.WithLabelValues("GET", "users", "/users/{id}", "200")))
+}
+
+func TestNewMetricsService_IsolatedRegistries(t *testing.T) {
+	// Each service owns its registry, so several can coexist
+	first := NewMetricsService()
+	second := NewMetricsService()
+
+	first.IncrementRequestCount("GET", "", "/", "200")
+	assert.Equal(t, 1, testutil.CollectAndCount(first.RequestCount))
+	assert.Equal(t, 0, testutil.CollectAndCount(second.RequestCount))
+}
+
+func TestNewMetricsService_Options(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metricsService := NewMetricsService(
+		WithRegisterer(registry),
+		WithNamespace("edge"),
+		WithSubsystem("gw"),
+		WithConstLabels(map[string]string{"instance_group": "blue"}),
+	)
+	metricsService.IncrementRequestCount("GET", "", "/", "200")
+
+	expected := `
+# HELP edge_gw_requests_total Total number of requests processed by the API Gateway
+# TYPE edge_gw_requests_total counter
+edge_gw_requests_total{instance_group="blue",method="GET",path="/",route="",status="200"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "edge_gw_requests_total"))
+}
+
+func TestMetricsService_Handler(t *testing.T) {
+	metricsService := NewMetricsService()
+	metricsService.IncrementRequestCount("GET", "", "/", "200")
+
+	w := httptest.NewRecorder()
+	metricsService.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `api_gateway_requests_total{method="GET",path="/",route="",status="200"} 1`)
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+
+	// A registerer that cannot be gathered is exposed by its owner
+	wrapped := NewMetricsService(WithRegisterer(prometheus.WrapRegistererWithPrefix("x_", prometheus.NewRegistry())))
+	w = httptest.NewRecorder()
+	wrapped.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestMetricsService_CardinalityGuard(t *testing.T) {
+	metricsService := NewMetricsService(WithMaxLabelValues(2))
+
+	for _, path := range []string{"/a", "/b", "/c", "/d", "/a"} {
+		metricsService.IncrementRequestCount("GET", "", path, "200")
+	}
+
+	counts := metricsService.RequestCount
+	assert.Equal(t, 2.0, testutil.ToFloat64(counts.WithLabelValues("GET", "", "/a", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(counts.WithLabelValues("GET", "", "/b", "200")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(counts.WithLabelValues("GET", "", OverflowValue, "200")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metricsService.LabelOverflow.WithLabelValues("path")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metricsService.LabelOverflow.WithLabelValues("route")))
+}
+
+func TestMetricsConfig_Validate(t *testing.T) {
+	assert.NoError(t, MetricsConfig{Namespace: "edge", Labels: map[string]string{"region": "eu"}}.Validate())
+
+	err := MetricsConfig{
+		Namespace: "edge-gw",
+		Subsystem: "1st",
+		Labels:    map[string]string{"__name": "x", "path": "y"},
+
+		MaxLabelValues: -1,
+	}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid namespace "edge-gw"`)
+	assert.Contains(t, err.Error(), `invalid subsystem "1st"`)
+	assert.Contains(t, err.Error(), `invalid label name "__name"`)
+	assert.Contains(t, err.Error(), `label "path" is already set by the gateway`)
+	assert.Contains(t, err.Error(), "maxLabelValues must not be negative")
 }
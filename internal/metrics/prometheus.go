@@ -1,9 +1,16 @@
 package metrics
 
 import (
+	"net/http"
+	"strconv"
+
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+const defaultNamespace = "api_gateway"
+
 // MetricsService handles metrics collection and reporting
 type MetricsService struct {
 	RequestCount      *prometheus.CounterVec
@@ -11,83 +18,388 @@ type MetricsService struct {
 	RequestSize       *prometheus.SummaryVec
 	ResponseSize      *prometheus.SummaryVec
 	ActiveConnections *prometheus.GaugeVec
+	RequestsInFlight  prometheus.Gauge
+	CertificateIssued *prometheus.CounterVec
+	CertificateErrors *prometheus.CounterVec
+	CertificateExpiry *prometheus.GaugeVec
+	LabelOverflow     *prometheus.CounterVec
+
+	UpstreamConnect         *prometheus.HistogramVec
+	UpstreamTLSHandshake    *prometheus.HistogramVec
+	UpstreamTimeToFirstByte *prometheus.HistogramVec
+	UpstreamConnections     *prometheus.CounterVec
+	UpstreamResponses       *prometheus.CounterVec
+	UpstreamErrors          *prometheus.CounterVec
+
+	LogEntriesDropped *prometheus.CounterVec
+
+	routes    *labelGuard
+	paths     *labelGuard
+	upstreams *labelGuard
+	gatherer  prometheus.Gatherer
+}
+
+type options struct {
+	registerer  prometheus.Registerer
+	namespace   string
+	subsystem   string
+	constLabels prometheus.Labels
+	maxValues   int
+}
+
+// Option configures a MetricsService
+type Option func(*options)
+
+// WithRegisterer registers the metrics with registerer instead of a registry
+// owned by the service. Handler serves registerer when it is also a
+// prometheus.Gatherer, as *prometheus.Registry is.
+func WithRegisterer(registerer prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registerer = registerer
+	}
+}
+
+// WithNamespace sets the prefix of every metric name; empty keeps the default
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		if namespace != "" {
+			o.namespace = namespace
+		}
+	}
+}
+
+// WithSubsystem sets the part of the metric names between the namespace and
+// the name
+func WithSubsystem(subsystem string) Option {
+	return func(o *options) {
+		o.subsystem = subsystem
+	}
+}
+
+// WithConstLabels adds labels with fixed values to every metric
+func WithConstLabels(labels map[string]string) Option {
+	return func(o *options) {
+		o.constLabels = labels
+	}
+}
+
+// WithMaxLabelValues caps the distinct values of the route, path and upstream
+// labels; zero keeps the default
+func WithMaxLabelValues(max int) Option {
+	return func(o *options) {
+		if max > 0 {
+			o.maxValues = max
+		}
+	}
+}
+
+// WithConfig applies the namespace, subsystem and labels of cfg
+func WithConfig(cfg MetricsConfig) Option {
+	return func(o *options) {
+		WithNamespace(cfg.Namespace)(o)
+		WithSubsystem(cfg.Subsystem)(o)
+		WithConstLabels(cfg.Labels)(o)
+		WithMaxLabelValues(cfg.MaxLabelValues)(o)
+	}
 }
 
 var _ Metrics = (*MetricsService)(nil)
 
-// NewMetricsService initializes a new metrics service
-func NewMetricsService() *MetricsService {
+// NewMetricsService initializes a new metrics service. Unless WithRegisterer
+// is given, the metrics are registered with a new registry, together with the
+// Go runtime and process collectors.
+func NewMetricsService(opts ...Option) *MetricsService {
+	o := options{namespace: defaultNamespace, maxValues: defaultMaxLabelValues}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.registerer == nil {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+		o.registerer = registry
+	}
+
 	m := &MetricsService{
 		RequestCount: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: "api_gateway_requests_total",
-				Help: "Total number of requests processed by the API Gateway",
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "requests_total",
+				ConstLabels: o.constLabels,
+				Help:        "Total number of requests processed by the API Gateway",
 			},
-			[]string{"method", "path", "status"},
+			[]string{"method", "route", "path", "status"},
 		),
 		RequestDuration: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
-				Name:    "api_gateway_request_duration_seconds",
-				Help:    "Request duration in seconds",
-				Buckets: prometheus.DefBuckets,
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "request_duration_seconds",
+				ConstLabels: o.constLabels,
+				Help:        "Request duration in seconds by HTTP protocol version",
+				Buckets:     prometheus.DefBuckets,
 			},
-			[]string{"method", "path"},
+			[]string{"method", "route", "path", "protocol"},
 		),
 		RequestSize: prometheus.NewSummaryVec(
 			prometheus.SummaryOpts{
-				Name:       "api_gateway_request_size_bytes",
-				Help:       "Request size in bytes",
-				Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "request_size_bytes",
+				ConstLabels: o.constLabels,
+				Help:        "Request size in bytes",
+				Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
 			},
-			[]string{"method", "path"},
+			[]string{"method", "route", "path"},
 		),
 		ResponseSize: prometheus.NewSummaryVec(
 			prometheus.SummaryOpts{
-				Name:       "api_gateway_response_size_bytes",
-				Help:       "Response size in bytes",
-				Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "response_size_bytes",
+				ConstLabels: o.constLabels,
+				Help:        "Response size in bytes",
+				Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
 			},
-			[]string{"method", "path"},
+			[]string{"method", "route", "path"},
 		),
 		ActiveConnections: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
-				Name: "api_gateway_active_connections",
-				Help: "Number of active connections",
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "active_connections",
+				ConstLabels: o.constLabels,
+				Help:        "Number of active connections",
+			},
+			[]string{"method", "route", "path"},
+		),
+		RequestsInFlight: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "requests_in_flight",
+				ConstLabels: o.constLabels,
+				Help:        "Number of requests currently being served, across all routes",
+			},
+		),
+		CertificateIssued: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "certificates_issued_total",
+				ConstLabels: o.constLabels,
+				Help:        "Number of certificates obtained or renewed via ACME",
+			},
+			[]string{"host"},
+		),
+		CertificateErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "certificate_errors_total",
+				ConstLabels: o.constLabels,
+				Help:        "Number of failures to provide an ACME certificate",
+			},
+			[]string{"host"},
+		),
+		CertificateExpiry: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "certificate_expiry_timestamp_seconds",
+				ConstLabels: o.constLabels,
+				Help:        "Expiry time of the current ACME certificate as a Unix timestamp",
+			},
+			[]string{"host"},
+		),
+		LabelOverflow: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "metric_label_overflow_total",
+				ConstLabels: o.constLabels,
+				Help:        "Number of observations whose label value was replaced because the label reached its limit of distinct values",
+			},
+			[]string{"label"},
+		),
+		UpstreamConnect: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "upstream_connect_duration_seconds",
+				ConstLabels: o.constLabels,
+				Help:        "Time to open a TCP connection to the upstream",
+				Buckets:     prometheus.DefBuckets,
+			},
+			[]string{"upstream"},
+		),
+		UpstreamTLSHandshake: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "upstream_tls_handshake_duration_seconds",
+				ConstLabels: o.constLabels,
+				Help:        "Time of the TLS handshake with the upstream",
+				Buckets:     prometheus.DefBuckets,
+			},
+			[]string{"upstream"},
+		),
+		UpstreamTimeToFirstByte: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "upstream_time_to_first_byte_seconds",
+				ConstLabels: o.constLabels,
+				Help:        "Time from sending the request to the first byte of the upstream response, connection setup included",
+				Buckets:     prometheus.DefBuckets,
+			},
+			[]string{"upstream"},
+		),
+		UpstreamConnections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "upstream_connections_total",
+				ConstLabels: o.constLabels,
+				Help:        "Number of connections used for upstream requests, by whether they were reused from the pool",
 			},
-			[]string{"method", "path"},
+			[]string{"upstream", "reused"},
+		),
+		UpstreamResponses: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "upstream_responses_total",
+				ConstLabels: o.constLabels,
+				Help:        "Number of responses received from the upstream by status code",
+			},
+			[]string{"upstream", "status"},
+		),
+		UpstreamErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "upstream_errors_total",
+				ConstLabels: o.constLabels,
+				Help:        "Number of upstream requests that failed without a response, by category (dns, refused, timeout, reset, tls, canceled, other)",
+			},
+			[]string{"upstream", "category"},
+		),
+		LogEntriesDropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   o.namespace,
+				Subsystem:   o.subsystem,
+				Name:        "log_entries_dropped_total",
+				ConstLabels: o.constLabels,
+				Help:        "Number of request log entries dropped, by reason (buffer_full, timeout, shutdown)",
+			},
+			[]string{"reason"},
 		),
 	}
+	m.routes = newLabelGuard(o.maxValues, m.LabelOverflow.WithLabelValues("route").Inc)
+	m.paths = newLabelGuard(o.maxValues, m.LabelOverflow.WithLabelValues("path").Inc)
+	m.upstreams = newLabelGuard(o.maxValues, m.LabelOverflow.WithLabelValues("upstream").Inc)
 
-	// Register metrics with Prometheus
-	prometheus.MustRegister(m.RequestCount)
-	prometheus.MustRegister(m.RequestDuration)
-	prometheus.MustRegister(m.RequestSize)
-	prometheus.MustRegister(m.ResponseSize)
-	prometheus.MustRegister(m.ActiveConnections)
+	o.registerer.MustRegister(
+		m.RequestCount,
+		m.RequestDuration,
+		m.RequestSize,
+		m.ResponseSize,
+		m.ActiveConnections,
+		m.RequestsInFlight,
+		m.CertificateIssued,
+		m.CertificateErrors,
+		m.CertificateExpiry,
+		m.LabelOverflow,
+		m.UpstreamConnect,
+		m.UpstreamTLSHandshake,
+		m.UpstreamTimeToFirstByte,
+		m.UpstreamConnections,
+		m.UpstreamResponses,
+		m.UpstreamErrors,
+		m.LogEntriesDropped,
+	)
+	m.gatherer, _ = o.registerer.(prometheus.Gatherer)
 
 	return m
 }
 
-func (m *MetricsService) IncrementRequestCount(method, path, status string) {
-	m.RequestCount.WithLabelValues(method, path, status).Inc()
+// Handler serves the metrics in the Prometheus exposition format. It responds
+// with 404 when the service was given a registerer it cannot gather from.
+func (m *MetricsService) Handler() http.Handler {
+	if m.gatherer == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(m.gatherer, promhttp.HandlerOpts{})
+}
+
+func (m *MetricsService) IncrementRequestCount(method, route, path, status string) {
+	m.RequestCount.WithLabelValues(method, m.routes.value(route), m.paths.value(path), status).Inc()
+}
+
+func (m *MetricsService) ObserveRequestDuration(method, route, path, protocol string, duration float64) {
+	m.RequestDuration.WithLabelValues(method, m.routes.value(route), m.paths.value(path), protocol).Observe(duration)
+}
+
+func (m *MetricsService) IncrementActiveConnections(method, route, path string) {
+	m.ActiveConnections.WithLabelValues(method, m.routes.value(route), m.paths.value(path)).Inc()
+}
+
+func (m *MetricsService) DecrementActiveConnections(method, route, path string) {
+	m.ActiveConnections.WithLabelValues(method, m.routes.value(route), m.paths.value(path)).Dec()
+}
+
+func (m *MetricsService) ObserveRequestSize(method, route, path string, size float64) {
+	m.RequestSize.WithLabelValues(method, m.routes.value(route), m.paths.value(path)).Observe(size)
+}
+
+func (m *MetricsService) ObserveResponseSize(method, route, path string, size float64) {
+	m.ResponseSize.WithLabelValues(method, m.routes.value(route), m.paths.value(path)).Observe(size)
+}
+
+func (m *MetricsService) SetRequestsInFlight(count float64) {
+	m.RequestsInFlight.Set(count)
+}
+
+func (m *MetricsService) IncrementCertificateIssued(host string) {
+	m.CertificateIssued.WithLabelValues(host).Inc()
+}
+
+func (m *MetricsService) IncrementCertificateErrors(host string) {
+	m.CertificateErrors.WithLabelValues(host).Inc()
+}
+
+func (m *MetricsService) SetCertificateExpiry(host string, timestamp float64) {
+	m.CertificateExpiry.WithLabelValues(host).Set(timestamp)
+}
+
+func (m *MetricsService) ObserveUpstreamConnect(upstream string, duration float64) {
+	m.UpstreamConnect.WithLabelValues(m.upstreams.value(upstream)).Observe(duration)
+}
+
+func (m *MetricsService) ObserveUpstreamTLSHandshake(upstream string, duration float64) {
+	m.UpstreamTLSHandshake.WithLabelValues(m.upstreams.value(upstream)).Observe(duration)
 }
 
-func (m *MetricsService) ObserveRequestDuration(method, path string, duration float64) {
-	m.RequestDuration.WithLabelValues(method, path).Observe(duration)
+func (m *MetricsService) ObserveUpstreamTimeToFirstByte(upstream string, duration float64) {
+	m.UpstreamTimeToFirstByte.WithLabelValues(m.upstreams.value(upstream)).Observe(duration)
 }
 
-func (m *MetricsService) IncrementActiveConnections(method, path string) {
-	m.ActiveConnections.WithLabelValues(method, path).Inc()
+func (m *MetricsService) IncrementUpstreamConnections(upstream string, reused bool) {
+	m.UpstreamConnections.WithLabelValues(m.upstreams.value(upstream), strconv.FormatBool(reused)).Inc()
 }
 
-func (m *MetricsService) DecrementActiveConnections(method, path string) {
-	m.ActiveConnections.WithLabelValues(method, path).Dec()
+func (m *MetricsService) IncrementUpstreamResponses(upstream, status string) {
+	m.UpstreamResponses.WithLabelValues(m.upstreams.value(upstream), status).Inc()
 }
 
-func (m *MetricsService) ObserveRequestSize(method, path string, size float64) {
-	m.RequestSize.WithLabelValues(method, path).Observe(size)
+func (m *MetricsService) IncrementUpstreamErrors(upstream, category string) {
+	m.UpstreamErrors.WithLabelValues(m.upstreams.value(upstream), category).Inc()
 }
 
-func (m *MetricsService) ObserveResponseSize(method, path string, size float64) {
-	m.ResponseSize.WithLabelValues(method, path).Observe(size)
+func (m *MetricsService) IncrementLogEntriesDropped(reason string) {
+	m.LogEntriesDropped.WithLabelValues(reason).Inc()
 }
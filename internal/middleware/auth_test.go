@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/leo-andrei/api-gateway/internal/logging"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	jwt := func(payload string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2ln"
+	}
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		subject       string
+	}{
+		{name: "missing", status: http.StatusUnauthorized},
+		{name: "not bearer", authorization: "Basic dXNlcjpwYXNz", status: http.StatusUnauthorized},
+		{name: "opaque token", authorization: "Bearer opaque-token", status: http.StatusOK},
+		{name: "jwt", authorization: "Bearer " + jwt(`{"sub":"alice","iat":1700000000}`), status: http.StatusOK, subject: "alice"},
+		{name: "jwt without subject", authorization: "Bearer " + jwt(`{"iat":1700000000}`), status: http.StatusOK},
+		{name: "malformed jwt", authorization: "Bearer a.!!!.c", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			ctx, access := logging.NewAccessContext(r.Context())
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r.WithContext(ctx))
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.subject, access.Subject)
+		})
+	}
+}
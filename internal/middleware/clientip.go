@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/leo-andrei/api-gateway/internal/clientip"
+)
+
+// ClientIPMiddleware resolves the client behind trusted proxies and stores it
+// in the request context, where clientip.FromRequest finds it
+func ClientIPMiddleware(next http.Handler, resolver *clientip.Resolver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := resolver.Resolve(r)
+		next.ServeHTTP(w, r.WithContext(clientip.NewContext(r.Context(), client)))
+	})
+}
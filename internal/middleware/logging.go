@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/leo-andrei/api-gateway/internal/clientip"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+	"github.com/leo-andrei/api-gateway/internal/requestid"
+)
+
+// LoggingMiddleware stores a child of logger carrying the request ID, method,
+// path and client of the request in its context, where logging.FromContext
+// finds it. It must run after ClientIPMiddleware and RequestIDMiddleware.
+func LoggingMiddleware(next http.Handler, logger logging.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestLogger := logger.With(logging.Fields{
+			"request_id": requestid.FromRequest(r),
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"client_ip":  clientip.FromRequest(r).IP,
+		})
+		next.ServeHTTP(w, r.WithContext(logging.NewContext(r.Context(), requestLogger)))
+	})
+}
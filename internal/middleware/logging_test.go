@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/leo-andrei/api-gateway/internal/clientip"
+	"github.com/leo-andrei/api-gateway/internal/logging"
+	"github.com/leo-andrei/api-gateway/internal/requestid"
+)
+
+func TestLoggingMiddleware(t *testing.T) {
+	root := &MockLogger{}
+	child := &MockLogger{}
+	root.On("With", logging.Fields{
+		"request_id": "abc-123",
+		"method":     "GET",
+		"path":       "/users/1",
+		"client_ip":  "203.0.113.7",
+	}).Return(child)
+
+	var fromContext logging.Logger
+	handler := ClientIPMiddleware(RequestIDMiddleware(LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fromContext = logging.FromContext(r.Context())
+	}), root), requestid.RequestIDConfig{}), clientip.NewResolver(nil))
+
+	r := httptest.NewRequest("GET", "/users/1", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	r.Header.Set(requestid.DefaultHeader, "abc-123")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	root.AssertExpectations(t)
+	assert.Same(t, child, fromContext)
+	child.AssertNotCalled(t, "With", mock.Anything)
+}
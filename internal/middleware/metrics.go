@@ -5,28 +5,60 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/gorilla/mux"
+
 	"github.com/leo-andrei/api-gateway/internal/logging"
 	"github.com/leo-andrei/api-gateway/internal/metrics"
 	"github.com/leo-andrei/api-gateway/pkg/responsewriter"
 )
 
-// MetricsMiddleware creates middleware for tracking metrics and logging
-func MetricsMiddleware(next http.Handler, metrics metrics.Metrics, logger logging.Logger) http.Handler {
+// DefaultUnmatchedPath is the path label of requests that matched no route
+const DefaultUnmatchedPath = "unmatched"
+
+// RouteLabels returns the name and path template of the mux route that
+// matched r, for use as metric labels. Requests that matched no route get an
+// empty name and the unmatched path, so raw paths never become labels.
+func RouteLabels(r *http.Request, unmatched string) (route, path string) {
+	if unmatched == "" {
+		unmatched = DefaultUnmatchedPath
+	}
+	current := mux.CurrentRoute(r)
+	if current == nil {
+		return "", unmatched
+	}
+	template, err := current.GetPathTemplate()
+	if err != nil {
+		return current.GetName(), unmatched
+	}
+	return current.GetName(), template
+}
+
+// MetricsMiddleware creates middleware for tracking metrics and logging.
+// Metrics are labelled by route, with unmatched as the path of requests that
+// matched no route.
+func MetricsMiddleware(next http.Handler, metrics metrics.Metrics, logger logging.Logger, unmatched string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
 		// Track active connections
-		path := r.URL.Path
+		route, path := RouteLabels(r, unmatched)
 		method := r.Method
-		metrics.IncrementActiveConnections(method, path)
-		defer metrics.DecrementActiveConnections(method, path)
+		metrics.IncrementActiveConnections(method, route, path)
+		defer metrics.DecrementActiveConnections(method, route, path)
 
 		// Request size
 		requestSize := 0
 		if r.ContentLength > 0 {
 			requestSize = int(r.ContentLength)
 		}
-		metrics.ObserveRequestSize(method, path, float64(requestSize))
+		metrics.ObserveRequestSize(method, route, path, float64(requestSize))
+
+		// Collect what the handlers learn about the request for the access log
+		ctx, access := logging.NewAccessContext(r.Context())
+		r = r.WithContext(ctx)
+		if mux.CurrentRoute(r) != nil {
+			access.SetRoute(route, path)
+		}
 
 		// Create a response writer wrapper to capture the status code and size
 		rw := responsewriter.NewResponseWriter(w)
@@ -34,18 +66,19 @@ func MetricsMiddleware(next http.Handler, metrics metrics.Metrics, logger loggin
 		// Call the next handler
 		next.ServeHTTP(rw, r)
 
-		// Request duration
+		// Request duration, split by protocol to compare HTTP/1.1, HTTP/2 and HTTP/3
 		duration := time.Since(start)
-		metrics.ObserveRequestDuration(method, path, duration.Seconds())
+		metrics.ObserveRequestDuration(method, route, path, r.Proto, duration.Seconds())
 
 		// Request count
 		status := fmt.Sprintf("%d", rw.StatusCode())
-		metrics.IncrementRequestCount(method, path, status)
+		metrics.IncrementRequestCount(method, route, path, status)
 
 		// Response size
-		metrics.ObserveResponseSize(method, path, float64(rw.Size()))
+		metrics.ObserveResponseSize(method, route, path, float64(rw.Size()))
 
 		// Log the request
+		access.ResponseHeader = rw.Header()
 		logger.LogRequest(r, duration, rw.StatusCode(), rw.Size())
 	})
 }
@@ -1,12 +1,17 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+
+	"github.com/leo-andrei/api-gateway/internal/logging"
 )
 
 // MockMetrics is a mock implementation of the Metrics interface
@@ -14,28 +19,72 @@ type MockMetrics struct {
 	mock.Mock
 }
 
-func (m *MockMetrics) IncrementRequestCount(method, path, status string) {
-	m.Called(method, path, status)
+func (m *MockMetrics) IncrementRequestCount(method, route, path, status string) {
+	m.Called(method, route, path, status)
+}
+
+func (m *MockMetrics) ObserveRequestDuration(method, route, path, protocol string, duration float64) {
+	m.Called(method, route, path, protocol, duration)
+}
+
+func (m *MockMetrics) IncrementActiveConnections(method, route, path string) {
+	m.Called(method, route, path)
+}
+
+func (m *MockMetrics) DecrementActiveConnections(method, route, path string) {
+	m.Called(method, route, path)
+}
+
+func (m *MockMetrics) ObserveRequestSize(method, route, path string, size float64) {
+	m.Called(method, route, path, size)
+}
+
+func (m *MockMetrics) ObserveResponseSize(method, route, path string, size float64) {
+	m.Called(method, route, path, size)
+}
+
+func (m *MockMetrics) SetRequestsInFlight(count float64) {
+	m.Called(count)
+}
+
+func (m *MockMetrics) IncrementCertificateIssued(host string) {
+	m.Called(host)
+}
+
+func (m *MockMetrics) IncrementCertificateErrors(host string) {
+	m.Called(host)
+}
+
+func (m *MockMetrics) SetCertificateExpiry(host string, timestamp float64) {
+	m.Called(host, timestamp)
+}
+
+func (m *MockMetrics) ObserveUpstreamConnect(upstream string, duration float64) {
+	m.Called(upstream, duration)
+}
+
+func (m *MockMetrics) ObserveUpstreamTLSHandshake(upstream string, duration float64) {
+	m.Called(upstream, duration)
 }
 
-func (m *MockMetrics) ObserveRequestDuration(method, path string, duration float64) {
-	m.Called(method, path, duration)
+func (m *MockMetrics) ObserveUpstreamTimeToFirstByte(upstream string, duration float64) {
+	m.Called(upstream, duration)
 }
 
-func (m *MockMetrics) IncrementActiveConnections(method, path string) {
-	m.Called(method, path)
+func (m *MockMetrics) IncrementUpstreamConnections(upstream string, reused bool) {
+	m.Called(upstream, reused)
 }
 
-func (m *MockMetrics) DecrementActiveConnections(method, path string) {
-	m.Called(method, path)
+func (m *MockMetrics) IncrementUpstreamResponses(upstream, status string) {
+	m.Called(upstream, status)
 }
 
-func (m *MockMetrics) ObserveRequestSize(method, path string, size float64) {
-	m.Called(method, path, size)
+func (m *MockMetrics) IncrementUpstreamErrors(upstream, category string) {
+	m.Called(upstream, category)
 }
 
-func (m *MockMetrics) ObserveResponseSize(method, path string, size float64) {
-	m.Called(method, path, size)
+func (m *MockMetrics) IncrementLogEntriesDropped(reason string) {
+	m.Called(reason)
 }
 
 // MockLogger is a mock implementation of the Logger interface
@@ -63,36 +112,84 @@ func (l *MockLogger) Infof(format string, args ...interface{}) {
 	l.Called(append([]interface{}{format}, args...)...)
 }
 
-func (l *MockLogger) Shutdown() {
-	l.Called()
+func (l *MockLogger) Shutdown(ctx context.Context) error {
+	return l.Called(ctx).Error(0)
+}
+
+func (l *MockLogger) Debug(v string) {
+	l.Called(v)
+}
+
+func (l *MockLogger) Debugf(format string, args ...interface{}) {
+	l.Called(append([]interface{}{format}, args...)...)
+}
+
+func (l *MockLogger) Warn(v string) {
+	l.Called(v)
+}
+
+func (l *MockLogger) Warnf(format string, args ...interface{}) {
+	l.Called(append([]interface{}{format}, args...)...)
+}
+
+func (l *MockLogger) Error(v string) {
+	l.Called(v)
+}
+
+func (l *MockLogger) Errorf(format string, args ...interface{}) {
+	l.Called(append([]interface{}{format}, args...)...)
+}
+
+func (l *MockLogger) With(fields logging.Fields) logging.Logger {
+	return l.Called(fields).Get(0).(logging.Logger)
 }
 
 func TestMetricsMiddleware(t *testing.T) {
 	mockMetrics := new(MockMetrics)
 	mockLogger := new(MockLogger)
 
-	// Set up expectations
-	mockMetrics.On("IncrementActiveConnections", "GET", "/test").Once()
-	mockMetrics.On("DecrementActiveConnections", "GET", "/test").Once()
-	mockMetrics.On("IncrementRequestCount", "GET", "/test", "200").Once()
-	mockMetrics.On("ObserveRequestDuration", "GET", "/test", mock.Anything).Once()
-	mockMetrics.On("ObserveRequestSize", "GET", "/test", mock.Anything).Once()
-	mockMetrics.On("ObserveResponseSize", "GET", "/test", mock.Anything).Once()
+	// Set up expectations: the path label is the route template, not the raw path
+	mockMetrics.On("IncrementActiveConnections", "GET", "user", "/users/{id}").Once()
+	mockMetrics.On("DecrementActiveConnections", "GET", "user", "/users/{id}").Once()
+	mockMetrics.On("IncrementRequestCount", "GET", "user", "/users/{id}", "200").Once()
+	mockMetrics.On("ObserveRequestDuration", "GET", "user", "/users/{id}", "HTTP/1.1", mock.Anything).Once()
+	mockMetrics.On("ObserveRequestSize", "GET", "user", "/users/{id}", mock.Anything).Once()
+	mockMetrics.On("ObserveResponseSize", "GET", "user", "/users/{id}", mock.Anything).Once()
 	mockLogger.On("LogRequest", mock.Anything, mock.Anything, 200, 0).Once()
 
 	// Create a test handler
 	handler := MetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
-	}), mockMetrics, mockLogger)
+	}), mockMetrics, mockLogger, "")
+	router := mux.NewRouter()
+	router.Handle("/users/{id}", handler).Name("user")
 
 	// Create a test request
-	req := httptest.NewRequest("GET", "/test", nil)
+	req := httptest.NewRequest("GET", "/users/123", nil)
 	rr := httptest.NewRecorder()
 
 	// Call the handler
-	handler.ServeHTTP(rr, req)
+	router.ServeHTTP(rr, req)
 
 	// Assert expectations
 	mockMetrics.AssertExpectations(t)
 	mockLogger.AssertExpectations(t)
 }
+
+func TestRouteLabels(t *testing.T) {
+	req := httptest.NewRequest("GET", "/users/123", nil)
+	route, path := RouteLabels(req, "")
+	assert.Equal(t, "", route)
+	assert.Equal(t, DefaultUnmatchedPath, path)
+
+	_, path = RouteLabels(req, "other")
+	assert.Equal(t, "other", path)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/users/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		route, path = RouteLabels(r, "")
+	})
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "", route)
+	assert.Equal(t, "/users/{id:[0-9]+}", path)
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/leo-andrei/api-gateway/internal/clientip"
+	"github.com/leo-andrei/api-gateway/internal/requestid"
+)
+
+// RequestIDMiddleware assigns the request an ID, keeping the one sent by the
+// client when the configuration allows it. The ID is stored in the request
+// context, set on the request headers so it is forwarded upstream, and echoed
+// in the response. It must run after ClientIPMiddleware.
+func RequestIDMiddleware(next http.Handler, cfg requestid.RequestIDConfig) http.Handler {
+	header := cfg.HeaderName()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(header)
+		switch {
+		case cfg.Incoming == requestid.IncomingIgnore,
+			cfg.Incoming == requestid.IncomingTrusted && !clientip.FromRequest(r).TrustedPeer,
+			!cfg.Valid(id):
+			id = requestid.New()
+		}
+
+		r.Header.Set(header, id)
+		w.Header().Set(header, id)
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
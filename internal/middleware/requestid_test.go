@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/leo-andrei/api-gateway/internal/clientip"
+	"github.com/leo-andrei/api-gateway/internal/requestid"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	resolver := clientip.NewResolver([]*net.IPNet{trusted})
+
+	tests := []struct {
+		name       string
+		cfg        requestid.RequestIDConfig
+		remoteAddr string
+		incoming   string
+		keep       bool
+	}{
+		{name: "generated when missing", remoteAddr: "203.0.113.7:1234"},
+		{name: "valid id accepted", remoteAddr: "203.0.113.7:1234", incoming: "abc-123", keep: true},
+		{name: "invalid id replaced", remoteAddr: "203.0.113.7:1234", incoming: "abc 123"},
+		{name: "ignored", cfg: requestid.RequestIDConfig{Incoming: requestid.IncomingIgnore}, remoteAddr: "10.0.0.1:1234", incoming: "abc-123"},
+		{name: "untrusted peer", cfg: requestid.RequestIDConfig{Incoming: requestid.IncomingTrusted}, remoteAddr: "203.0.113.7:1234", incoming: "abc-123"},
+		{name: "trusted peer", cfg: requestid.RequestIDConfig{Incoming: requestid.IncomingTrusted}, remoteAddr: "10.0.0.1:1234", incoming: "abc-123", keep: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fromContext, forwarded string
+			handler := ClientIPMiddleware(RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fromContext = requestid.FromRequest(r)
+				forwarded = r.Header.Get(requestid.DefaultHeader)
+			}), tt.cfg), resolver)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.incoming != "" {
+				req.Header.Set(requestid.DefaultHeader, tt.incoming)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if tt.keep {
+				assert.Equal(t, tt.incoming, fromContext)
+			} else {
+				_, err := uuid.Parse(fromContext)
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, fromContext, forwarded)
+			assert.Equal(t, fromContext, rr.Header().Get(requestid.DefaultHeader))
+		})
+	}
+}
+
+func TestRequestIDMiddleware_CustomHeader(t *testing.T) {
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		requestid.RequestIDConfig{Header: "X-Correlation-ID"})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Correlation-ID", "abc")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, "abc", rr.Header().Get("X-Correlation-ID"))
+	assert.Empty(t, rr.Header().Get(requestid.DefaultHeader))
+}
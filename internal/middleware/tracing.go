@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/leo-andrei/api-gateway/internal/clientip"
+	"github.com/leo-andrei/api-gateway/internal/requestid"
+	"github.com/leo-andrei/api-gateway/internal/tracing"
+	"github.com/leo-andrei/api-gateway/pkg/responsewriter"
+)
+
+// TracingMiddleware continues the trace of the request, if any, in a server
+// span named after the matched route. Requests that matched no route get a
+// span named after their method only.
+func TracingMiddleware(next http.Handler, tracer *tracing.Tracing) http.Handler {
+	if tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attributes := []attribute.KeyValue{
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			semconv.URLScheme(requestScheme(r)),
+			semconv.ServerAddress(r.Host),
+			semconv.ClientAddress(clientip.FromRequest(r).IP),
+			semconv.NetworkProtocolVersion(protocolVersion(r)),
+		}
+		if id := requestid.FromRequest(r); id != "" {
+			attributes = append(attributes, attribute.String("gateway.request_id", id))
+		}
+		if agent := r.UserAgent(); agent != "" {
+			attributes = append(attributes, semconv.UserAgentOriginal(agent))
+		}
+		name := r.Method
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				name += " " + template
+				attributes = append(attributes, semconv.HTTPRoute(template))
+			}
+			if routeName := current.GetName(); routeName != "" {
+				attributes = append(attributes, attribute.String("gateway.route.name", routeName))
+			}
+		}
+
+		ctx := tracer.Extract(r.Context(), r.Header)
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attributes...),
+		)
+		defer span.End()
+
+		rw := responsewriter.NewResponseWriter(w)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rw.StatusCode()))
+		if rw.StatusCode() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rw.StatusCode()))
+		}
+	})
+}
+
+// requestScheme returns the scheme the client used to reach the gateway
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// protocolVersion returns the HTTP version as in network.protocol.version,
+// e.g. "1.1" or "2"
+func protocolVersion(r *http.Request) string {
+	if r.ProtoMajor == 1 {
+		return fmt.Sprintf("1.%d", r.ProtoMinor)
+	}
+	return strconv.Itoa(r.ProtoMajor)
+}
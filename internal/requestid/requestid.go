@@ -0,0 +1,104 @@
+// Package requestid assigns every request an ID, accepting the one sent by
+// the client when allowed, and carries it in the request context
+package requestid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// DefaultHeader carries the request ID unless configured otherwise
+const DefaultHeader = "X-Request-ID"
+
+const defaultMaxLength = 128
+
+// Policies for IDs sent by the client
+const (
+	IncomingAccept  = "accept"  // Keep any valid ID
+	IncomingTrusted = "trusted" // Keep valid IDs from trusted proxies only
+	IncomingIgnore  = "ignore"  // Always generate a new ID
+)
+
+// RequestIDConfig configures request IDs
+type RequestIDConfig struct {
+	Header    string `yaml:"header,omitempty" json:"header,omitempty" toml:"header,omitempty"`         // X-Request-ID when empty
+	Incoming  string `yaml:"incoming,omitempty" json:"incoming,omitempty" toml:"incoming,omitempty"`   // accept, trusted or ignore, accept when empty
+	MaxLength int    `yaml:"maxLength,omitempty" json:"maxLength,omitempty" toml:"maxLength,omitzero"` // Longest incoming ID kept, 128 when zero
+}
+
+// Validate checks the incoming policy and length
+func (c RequestIDConfig) Validate() error {
+	var errs []error
+	switch c.Incoming {
+	case "", IncomingAccept, IncomingTrusted, IncomingIgnore:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported incoming policy %q", c.Incoming))
+	}
+	if c.MaxLength < 0 {
+		errs = append(errs, errors.New("maxLength must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// HeaderName returns the configured header, or the default
+func (c RequestIDConfig) HeaderName() string {
+	if c.Header == "" {
+		return DefaultHeader
+	}
+	return http.CanonicalHeaderKey(c.Header)
+}
+
+// Valid reports whether an incoming ID may be kept: it is not empty, not
+// longer than the configured maximum and made of characters that are safe to
+// log and forward
+func (c RequestIDConfig) Valid(id string) bool {
+	maxLength := c.MaxLength
+	if maxLength == 0 {
+		maxLength = defaultMaxLength
+	}
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, ch := range id {
+		if !isIDChar(ch) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIDChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '_' || c == '.' || c == ':' || c == '+' || c == '/' || c == '=' || c == '@'
+}
+
+// New generates a request ID: a UUIDv7, which sorts by creation time
+func New() string {
+	if id, err := uuid.NewV7(); err == nil {
+		return id.String()
+	}
+	return uuid.NewString()
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID stored in ctx, or an empty string
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// FromRequest returns the request ID of r, or an empty string when none was
+// assigned
+func FromRequest(r *http.Request) string {
+	return FromContext(r.Context())
+}
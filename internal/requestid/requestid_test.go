@@ -0,0 +1,57 @@
+package requestid
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	first, second := New(), New()
+	assert.NotEqual(t, first, second)
+
+	id, err := uuid.Parse(first)
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Version(7), id.Version())
+	// UUIDv7 sort by creation time
+	assert.Less(t, first, second)
+}
+
+func TestRequestIDConfig_Valid(t *testing.T) {
+	cfg := RequestIDConfig{}
+	assert.True(t, cfg.Valid("0190b6a2-7c4e-7c1e-8d3a-2f1e4b5c6d7e"))
+	assert.True(t, cfg.Valid("trace:abc/123+x=y@z_1.2"))
+	assert.False(t, cfg.Valid(""))
+	assert.False(t, cfg.Valid("has space"))
+	assert.False(t, cfg.Valid("line\nbreak"))
+	assert.False(t, cfg.Valid(`quote"`))
+	assert.False(t, cfg.Valid(strings.Repeat("a", 129)))
+	assert.True(t, RequestIDConfig{MaxLength: 200}.Valid(strings.Repeat("a", 129)))
+}
+
+func TestRequestIDConfig_Validate(t *testing.T) {
+	assert.NoError(t, RequestIDConfig{Incoming: IncomingTrusted}.Validate())
+
+	err := RequestIDConfig{Incoming: "sometimes", MaxLength: -1}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unsupported incoming policy "sometimes"`)
+	assert.Contains(t, err.Error(), "maxLength must not be negative")
+}
+
+func TestRequestIDConfig_HeaderName(t *testing.T) {
+	assert.Equal(t, DefaultHeader, RequestIDConfig{}.HeaderName())
+	assert.Equal(t, "X-Correlation-Id", RequestIDConfig{Header: "x-correlation-id"}.HeaderName())
+}
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	assert.Empty(t, FromRequest(r))
+
+	r = r.WithContext(NewContext(context.Background(), "abc"))
+	assert.Equal(t, "abc", FromRequest(r))
+}
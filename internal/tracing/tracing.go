@@ -0,0 +1,261 @@
+// Package tracing instruments the gateway with OpenTelemetry: it continues
+// the trace of inbound requests, starts a server span per request and a
+// client span per upstream attempt, and exports them over OTLP.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "github.com/leo-andrei/api-gateway"
+	defaultServiceName  = "api-gateway"
+)
+
+// Exporters
+const (
+	ExporterGRPC = "grpc"
+	ExporterHTTP = "http"
+)
+
+// Samplers, named as in OTEL_TRACES_SAMPLER
+const (
+	SamplerAlwaysOn                = "always_on"
+	SamplerAlwaysOff               = "always_off"
+	SamplerTraceIDRatio            = "traceidratio"
+	SamplerParentBasedAlwaysOn     = "parentbased_always_on"
+	SamplerParentBasedAlwaysOff    = "parentbased_always_off"
+	SamplerParentBasedTraceIDRatio = "parentbased_traceidratio"
+)
+
+// Propagators
+const (
+	PropagatorTraceContext = "tracecontext"
+	PropagatorBaggage      = "baggage"
+	PropagatorB3           = "b3"
+	PropagatorB3Multi      = "b3multi"
+)
+
+// TracingConfig configures tracing. The OTLP exporter also honours the
+// standard OTEL_EXPORTER_OTLP_* environment variables for anything left empty.
+type TracingConfig struct {
+	Enabled     bool              `yaml:"enabled" json:"enabled" toml:"enabled"`
+	ServiceName string            `yaml:"serviceName,omitempty" json:"serviceName,omitempty" toml:"serviceName,omitempty"` // "api-gateway" when empty
+	Exporter    string            `yaml:"exporter,omitempty" json:"exporter,omitempty" toml:"exporter,omitempty"`          // OTLP transport, grpc or http, grpc when empty
+	Endpoint    string            `yaml:"endpoint,omitempty" json:"endpoint,omitempty" toml:"endpoint,omitempty"`          // Collector host:port
+	Insecure    bool              `yaml:"insecure,omitempty" json:"insecure,omitempty" toml:"insecure,omitempty"`          // Export without TLS
+	Headers     map[string]string `yaml:"headers,omitempty" json:"headers,omitempty" toml:"headers,omitempty"`             // Sent with every export, e.g. for authentication
+	Sampler     string            `yaml:"sampler,omitempty" json:"sampler,omitempty" toml:"sampler,omitempty"`             // parentbased_always_on when empty
+	SampleRatio float64           `yaml:"sampleRatio,omitempty" json:"sampleRatio,omitempty" toml:"sampleRatio,omitzero"`  // Fraction of traces kept by the ratio samplers
+	Propagators []string          `yaml:"propagators,omitempty" json:"propagators,omitempty" toml:"propagators,omitempty"` // tracecontext and baggage when empty
+}
+
+// Validate checks the exporter, sampler and propagators
+func (c TracingConfig) Validate() error {
+	var errs []error
+	switch c.Exporter {
+	case "", ExporterGRPC, ExporterHTTP:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported exporter %q", c.Exporter))
+	}
+	switch c.Sampler {
+	case "", SamplerAlwaysOn, SamplerAlwaysOff, SamplerTraceIDRatio,
+		SamplerParentBasedAlwaysOn, SamplerParentBasedAlwaysOff, SamplerParentBasedTraceIDRatio:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported sampler %q", c.Sampler))
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("sampleRatio %v must be between 0 and 1", c.SampleRatio))
+	}
+	for _, name := range c.Propagators {
+		if _, err := propagator(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Tracing creates the gateway's spans. A nil *Tracing is valid and traces
+// nothing.
+type Tracing struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+type options struct {
+	exporter sdktrace.SpanExporter
+}
+
+// Option configures a Tracing
+type Option func(*options)
+
+// WithExporter sends the spans to exporter instead of an OTLP collector, e.g.
+// to a tracetest.InMemoryExporter in tests
+func WithExporter(exporter sdktrace.SpanExporter) Option {
+	return func(o *options) {
+		o.exporter = exporter
+	}
+}
+
+// New sets up tracing as configured. It returns nil when tracing is disabled.
+func New(ctx context.Context, cfg TracingConfig, opts ...Option) (*Tracing, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.exporter == nil {
+		exporter, err := newOTLPExporter(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+		}
+		o.exporter = exporter
+	}
+
+	propagators := cfg.Propagators
+	if len(propagators) == 0 {
+		propagators = []string{PropagatorTraceContext, PropagatorBaggage}
+	}
+	var composite []propagation.TextMapPropagator
+	for _, name := range propagators {
+		p, err := propagator(name)
+		if err != nil {
+			return nil, err
+		}
+		composite = append(composite, p)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(o.exporter),
+		sdktrace.WithSampler(sampler(cfg)),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	return &Tracing{
+		provider:   provider,
+		tracer:     provider.Tracer(instrumentationName),
+		propagator: propagation.NewCompositeTextMapPropagator(composite...),
+	}, nil
+}
+
+func newOTLPExporter(ctx context.Context, cfg TracingConfig) (sdktrace.SpanExporter, error) {
+	if cfg.Exporter == ExporterHTTP {
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	}
+
+	var opts []otlptracegrpc.Option
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracegrpc.WithHeaders(cfg.Headers))
+	}
+	return otlptracegrpc.New(ctx, opts...)
+}
+
+func sampler(cfg TracingConfig) sdktrace.Sampler {
+	switch cfg.Sampler {
+	case SamplerAlwaysOn:
+		return sdktrace.AlwaysSample()
+	case SamplerAlwaysOff:
+		return sdktrace.NeverSample()
+	case SamplerTraceIDRatio:
+		return sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	case SamplerParentBasedAlwaysOff:
+		return sdktrace.ParentBased(sdktrace.NeverSample())
+	case SamplerParentBasedTraceIDRatio:
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))
+	default:
+		return sdktrace.ParentBased(sdktrace.AlwaysSample())
+	}
+}
+
+func propagator(name string) (propagation.TextMapPropagator, error) {
+	switch strings.ToLower(name) {
+	case PropagatorTraceContext:
+		return propagation.TraceContext{}, nil
+	case PropagatorBaggage:
+		return propagation.Baggage{}, nil
+	case PropagatorB3:
+		return b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)), nil
+	case PropagatorB3Multi:
+		return b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)), nil
+	default:
+		return nil, fmt.Errorf("unsupported propagator %q", name)
+	}
+}
+
+// Extract returns ctx carrying the trace context found in the headers of an
+// inbound request
+func (t *Tracing) Extract(ctx context.Context, header http.Header) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return t.propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject writes the trace context of ctx into the headers of an outbound
+// request
+func (t *Tracing) Inject(ctx context.Context, header http.Header) {
+	if t == nil {
+		return
+	}
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Start starts a span, or returns ctx and a no-op span when t is nil
+func (t *Tracing) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return t.tracer.Start(ctx, name, opts...)
+}
+
+// ForceFlush exports the spans ended so far
+func (t *Tracing) ForceFlush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.provider.ForceFlush(ctx)
+}
+
+// Shutdown exports the remaining spans and stops the exporter
+func (t *Tracing) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.provider.Shutdown(ctx)
+}
@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingConfig_Validate(t *testing.T) {
+	assert.NoError(t, TracingConfig{Exporter: ExporterHTTP, Sampler: SamplerParentBasedTraceIDRatio, SampleRatio: 0.1, Propagators: []string{"b3"}}.Validate())
+
+	err := TracingConfig{Exporter: "zipkin", Sampler: "sometimes", SampleRatio: 2, Propagators: []string{"jaeger"}}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unsupported exporter "zipkin"`)
+	assert.Contains(t, err.Error(), `unsupported sampler "sometimes"`)
+	assert.Contains(t, err.Error(), "sampleRatio 2 must be between 0 and 1")
+	assert.Contains(t, err.Error(), `unsupported propagator "jaeger"`)
+}
+
+func TestNew_Disabled(t *testing.T) {
+	tracer, err := New(context.Background(), TracingConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, tracer)
+
+	// A nil tracer does nothing
+	header := http.Header{}
+	ctx, span := tracer.Start(context.Background(), "request")
+	span.End()
+	tracer.Inject(ctx, header)
+	assert.Empty(t, header)
+	assert.False(t, span.SpanContext().IsValid())
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+}
+
+func TestTracing_PropagatesTraceContext(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer, err := New(context.Background(), TracingConfig{Enabled: true}, WithExporter(exporter))
+	require.NoError(t, err)
+	defer tracer.Shutdown(context.Background())
+
+	inbound := http.Header{}
+	inbound.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, span := tracer.Start(tracer.Extract(context.Background(), inbound), "request")
+	outbound := http.Header{}
+	tracer.Inject(ctx, outbound)
+	span.End()
+
+	require.NoError(t, tracer.ForceFlush(context.Background()))
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent.SpanID().String())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+spans[0].SpanContext.SpanID().String()+"-01", outbound.Get("traceparent"))
+}
+
+func TestTracing_B3(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer, err := New(context.Background(), TracingConfig{Enabled: true, Propagators: []string{PropagatorB3}}, WithExporter(exporter))
+	require.NoError(t, err)
+	defer tracer.Shutdown(context.Background())
+
+	inbound := http.Header{}
+	inbound.Set("b3", "4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1")
+	ctx := tracer.Extract(context.Background(), inbound)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", trace.SpanContextFromContext(ctx).TraceID().String())
+
+	outbound := http.Header{}
+	tracer.Inject(ctx, outbound)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1", outbound.Get("b3"))
+	assert.Empty(t, outbound.Get("traceparent"))
+}
+
+func TestNew_Sampling(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer, err := New(context.Background(), TracingConfig{Enabled: true, Sampler: SamplerAlwaysOff}, WithExporter(exporter))
+	require.NoError(t, err)
+	defer tracer.Shutdown(context.Background())
+
+	_, span := tracer.Start(context.Background(), "request")
+	span.End()
+	require.NoError(t, tracer.ForceFlush(context.Background()))
+	assert.Empty(t, exporter.GetSpans())
+}
@@ -2,15 +2,24 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/leo-andrei/api-gateway/config"
+	"github.com/leo-andrei/api-gateway/internal/admin"
 	"github.com/leo-andrei/api-gateway/internal/gateway"
 	"github.com/leo-andrei/api-gateway/internal/logging"
 	"github.com/leo-andrei/api-gateway/internal/metrics"
+	"github.com/leo-andrei/api-gateway/internal/tracing"
+)
+
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	logFlushTimeout        = 5 * time.Second
 )
 
 func main() {
@@ -19,27 +28,68 @@ func main() {
 	if configPath == "" {
 		configPath = "config.yaml" // Default fallback
 	}
-	cfg, err := config.LoadConfig(configPath)
+	var cfg *config.Config
+	configFormat, err := parseConfigFormat(os.Getenv("CONFIG_FORMAT"))
+	if err == nil {
+		cfg, err = config.LoadConfigWithFormat(configPath, configFormat)
+	}
 	if err != nil {
 		logger := logging.NewLogService(logging.LoggingConfig{Level: "error", Format: "text"})
 		logger.Fatalf("Error loading config: %v", err)
 	}
 
 	// Initialize services
-	logger := logging.NewLogService(cfg.Logging)
-	metrics := metrics.NewMetricsService()
+	metrics := metrics.NewMetricsService(metrics.WithConfig(cfg.Metrics))
+	logger := logging.NewLogService(cfg.Logging, logging.WithMetrics(metrics))
+	tracer, err := tracing.New(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Fatalf("Error setting up tracing: %v", err)
+	}
 
 	// Create and run the gateway
-	gw := gateway.NewGateway(cfg, logger, metrics)
+	gw := gateway.NewGateway(cfg, logger, metrics, tracer)
 	gw.SetupRoutes()
 
+	// Route changes made through the admin API are applied to the gateway
+	// and persisted back to the config file
+	store := config.NewStore(configPath, configFormat, cfg)
+	store.Subscribe(gw.ApplyConfig)
+
+	var adminServer *admin.Server
+	if cfg.Admin.Port != 0 {
+		adminServer = admin.NewServer(cfg.Admin, store, logger)
+		if cfg.Health.AdminPort {
+			adminServer.Mount(gw.HealthHandler())
+		}
+		go func() {
+			logger.Infof("Starting admin API on %s", adminServer.Address())
+			if err := adminServer.Run(); err != nil && err != http.ErrServerClosed {
+				logger.Fatalf("Error running admin API: %v", err)
+			}
+		}()
+	}
+
+	// Reload the config file on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			_, version, err := store.Reload(config.Origin{Source: config.SourceFile, Actor: "SIGHUP"})
+			if err != nil {
+				logger.Errorf("Error reloading config from %s: %v", configPath, err)
+				continue
+			}
+			logger.Infof("Reloaded config from %s as version %d", configPath, version)
+		}
+	}()
+
 	// Setup graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
 		logger.Infof("Starting API Gateway on port %d", cfg.Server.Port)
-		if err := gw.Run(); err != nil {
+		if err := gw.Run(); err != nil && err != http.ErrServerClosed {
 			logger.Fatalf("Error running gateway: %v", err)
 		}
 		logger.Infof("Started API Gateway on port %d", cfg.Server.Port)
@@ -48,13 +98,44 @@ func main() {
 	<-stop
 	logger.Info("Shutting down API Gateway...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Fail readiness first and keep serving while load balancers notice
+	gw.Drain()
+	if delay := cfg.Server.Shutdown.DrainDelay.Std(); delay > 0 {
+		logger.Infof("Draining for %s with %d requests in flight", delay, gw.InFlight())
+		time.Sleep(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.Shutdown.Timeout.Or(defaultShutdownTimeout))
 	defer cancel()
 
+	logger.Infof("Waiting for %d requests in flight", gw.InFlight())
 	if err := gw.Shutdown(ctx); err != nil {
-		logger.Fatalf("Error shutting down server: %v", err)
+		logger.Errorf("Error shutting down server: %v", err)
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			logger.Errorf("Error shutting down admin API: %v", err)
+		}
+	}
+	if err := tracer.Shutdown(ctx); err != nil {
+		logger.Errorf("Error flushing traces: %v", err)
 	}
-	logger.Shutdown()
-
 	logger.Info("API Gateway stopped")
+
+	// The request logs get their own time budget, which a slow shutdown of
+	// the servers may have used up
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), logFlushTimeout)
+	defer cancelFlush()
+	if err := logger.Shutdown(flushCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error flushing logs: %v\n", err)
+	}
+}
+
+// parseConfigFormat reads an explicit config format, leaving detection to the
+// file extension when none is given
+func parseConfigFormat(name string) (config.Format, error) {
+	if name == "" {
+		return "", nil
+	}
+	return config.ParseFormat(name)
 }